@@ -0,0 +1,139 @@
+package buster
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAsyncTimeout is the error with which an operation started by
+// Generator.DoAsync fails if it isn't completed within its timeout.
+var ErrAsyncTimeout = errors.New("buster: asynchronous operation timed out")
+
+// DoAsync generates load using the given function, which is expected to
+// initiate an operation which completes asynchronously, e.g. when an
+// acknowledgement arrives. The function must arrange for done to be called
+// once, from any goroutine, with the outcome of the operation. If it returns an
+// error instead, the operation failed to start and done must not be called.
+// Latencies are measured from when each operation was due to start until done
+// is called.
+//
+// Up to max operations may be outstanding at once. While that many are, the
+// worker waits for one to complete before starting the next, so the achieved
+// rate falls and the wait is included in the next operation's latency. An
+// operation which isn't completed within timeout fails with ErrAsyncTimeout,
+// and any later call to its done is ignored; if timeout is zero, operations
+// never time out. Once the run is over, DoAsync waits for the outstanding
+// operations to complete or time out before returning. The time each
+// operation is outstanding counts towards Result.EffectiveConcurrency, which
+// is then the mean number of outstanding operations. DoAsync panics if max
+// isn't positive.
+func (gen *Generator) DoAsync(max int, timeout time.Duration, f func(done func(error)) error) error {
+	if max <= 0 {
+		panic("buster: DoAsync max must be positive")
+	}
+	if err := gen.begin(); err != nil {
+		return err
+	}
+
+	if gen.preflight {
+		gen.once(func() error {
+			result := make(chan error, 1)
+			if err := f(func(err error) {
+				select {
+				case result <- err:
+				default:
+				}
+			}); err != nil {
+				return err
+			}
+
+			if timeout <= 0 {
+				return <-result
+			}
+			select {
+			case err := <-result:
+				return err
+			case <-after(gen.clock, timeout):
+				return ErrAsyncTimeout
+			}
+		})
+		return nil
+	}
+
+	over := after(gen.clock, gen.duration+gen.warmup)
+	cancelled := gen.Context().Done()
+	warmed := gen.now().Add(gen.warmup)
+
+	slots := make(chan struct{}, max)
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	// operations complete concurrently, so recording them is serialized
+	var mu sync.Mutex
+
+	ticker := gen.clock.NewTicker(gen.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case start := <-ticker.C():
+			select {
+			case slots <- struct{}{}:
+			case <-cancelled:
+				return nil
+			case <-over:
+				return nil
+			}
+
+			gen.seq++
+			measured := start.After(warmed) && gen.seq > int64(gen.warmupOps)
+			began := gen.now()
+
+			var once sync.Once
+			complete := func(err error) {
+				once.Do(func() {
+					end := gen.clamp(start, gen.now())
+					<-slots
+					defer pending.Done()
+
+					if !measured || gen.abandoned(err) {
+						return
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					gen.busy += end.Sub(began)
+					if err == nil {
+						gen.succeed(us(end.Sub(start)), 0)
+					} else {
+						gen.fail(err)
+					}
+					gen.observe(start, end.Sub(start), err)
+				})
+			}
+
+			pending.Add(1)
+			var timer Timer
+			if timeout > 0 {
+				timer = gen.clock.AfterFunc(timeout, func() {
+					complete(ErrAsyncTimeout)
+				})
+			}
+
+			done := func(err error) {
+				if timer != nil {
+					timer.Stop()
+				}
+				complete(err)
+			}
+			if err := f(done); err != nil {
+				done(err)
+			}
+		case <-cancelled:
+			return nil
+		case <-over:
+			return nil
+		}
+	}
+}
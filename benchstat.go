@@ -0,0 +1,56 @@
+package buster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WriteBenchmarks writes the given results to w in the format of Go benchmark
+// output, so that they can be compared with tools like benchstat. It begins
+// with goos and goarch configuration lines, followed by a line for each
+// result:
+//
+//	Benchmark<name>/concurrency=<c>-<GOMAXPROCS>  <success>  <mean> ns/op  <throughput> ops/s  <p50> p50-ns  <p99> p99-ns  <p99.9> p99.9-ns  <error rate> errors/op
+//
+// where the iteration count is the number of successful operations, ns/op is
+// their mean latency, and errors/op is the proportion of operations which
+// failed. Whitespace in name is replaced with underscores, and its first
+// letter is capitalized, as in the name of a benchmark function. Results of
+// repeated runs at the same concurrency level should be written as separate
+// lines with the same name, which benchstat treats as samples.
+func WriteBenchmarks(w io.Writer, name string, results []Result) error {
+	name = strings.Join(strings.Fields(name), "_")
+	if r, n := utf8.DecodeRuneInString(name); n > 0 {
+		name = string(unicode.ToUpper(r)) + name[n:]
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "goos: %s\n", runtime.GOOS)
+	fmt.Fprintf(out, "goarch: %s\n", runtime.GOARCH)
+
+	for _, r := range results {
+		var mean float64
+		var p50, p99, p999 int64
+		if r.Latency != nil {
+			mean = r.Latency.Mean() * 1000
+			p50 = r.Latency.ValueAtQuantile(50) * 1000
+			p99 = r.Latency.ValueAtQuantile(99) * 1000
+			p999 = r.Latency.ValueAtQuantile(99.9) * 1000
+		}
+
+		errorRate := 0.0
+		if total := r.Success + r.Failure; total > 0 {
+			errorRate = float64(r.Failure) / float64(total)
+		}
+
+		fmt.Fprintf(out, "Benchmark%s/concurrency=%d-%d\t%d\t%.0f ns/op\t%.2f ops/s\t%d p50-ns\t%d p99-ns\t%d p99.9-ns\t%.4f errors/op\n",
+			name, r.Concurrency, runtime.GOMAXPROCS(0), r.Success,
+			mean, throughput(r), p50, p99, p999, errorRate)
+	}
+	return out.Flush()
+}
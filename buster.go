@@ -5,12 +5,30 @@
 //
 // The generic nature of Buster makes it suitable for load testing many
 // different systems—HTTP servers, databases, RPC services, etc.
+//
+// Buster keeps no mutable package-level state: each run has its own workers,
+// generators, counters, and histograms, so any number of benches may run
+// concurrently in one process, e.g. in a service which runs them on demand.
+// A Bench is a plain value which runs don't modify, and may be shared by
+// concurrent runs as long as its callbacks are safe for concurrent use. A
+// Generator belongs to its worker and must not be shared. A Result is
+// independent of the run which returned it, but copies of a Result share its
+// maps, so a copy shouldn't be modified with Result.Add while the original is
+// in use. The only measurements which aren't per-run are those of
+// Bench.SampleResources, which are of the whole process.
 package buster
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,138 +38,1995 @@ import (
 
 // A Generator is a type passed to Job instances to manage load generation.
 type Generator struct {
-	hist                     *hdrhistogram.Histogram
-	success, failure         *uint64
+	// configuration
+	id                       int
 	warmup, duration, period time.Duration
+	warmupOps, sample        int
+	minOps                   int
+	min, max                 int64
+	widen, autoMax           bool
+	successStatus            func(int) bool
+	onOp                     func(OpResult)
+	arrivals                 Arrivals
+	stagger, probe           bool
+	partition                int
+	preflight, debug         bool
+	rand                     *rand.Rand
+	barrier                  *sync.WaitGroup
+	start                    time.Time
+	arrive                   sync.Once
+	clock                    Clock
+	ctx                      context.Context
+	cancelledErr             func(error) bool
+
+	// measurements
+	hist                 *hdrhistogram.Histogram
+	counts               *counters
+	errs, partitionErrs  *errorSampler
+	tags                 *tagRegistry
+	tagged               map[string]*Result
+	count                int64
+	successes, failures  uint64
+	appFailures          uint64
+	netFailures          uint64
+	overflow             []overflow
+	busy, delay          time.Duration
+	delays               int64
+	splits               map[string]*hdrhistogram.Histogram
+	connHist, streamHist *hdrhistogram.Histogram
+	serverHist, itemHist *hdrhistogram.Histogram
+	weightedHist         *hdrhistogram.Histogram
+	batch                []int64
+	statuses             map[int]int
+	metric               interface{}
+	preflightErr         error
+	reduce               func(a, b interface{}) interface{}
+	err                  error
+
+	// per-operation state
+	state      int32
+	ended      int32
+	measured   bool
+	seq        int64
+	last, mark time.Time
+	pending    []split
 }
 
 // Do generates load using the given function.
 func (gen *Generator) Do(f func() error) error {
-	ticker := time.NewTicker(gen.period)
-	defer ticker.Stop()
+	return gen.loop(f, gen.ok(gen.interval()))
+}
+
+// DoEvery generates load using the given function, waiting at least interval
+// between the starts of consecutive operations. If an operation takes longer
+// than interval, the next one starts as soon as it finishes. This models a
+// polling client, so the bench's rate is ignored, and latencies are measured
+// from the start of each operation without correcting for coordinated
+// omission.
+func (gen *Generator) DoEvery(interval time.Duration, f func() error) error {
+	if err := gen.begin(); err != nil {
+		return err
+	}
+
+	if gen.preflight {
+		gen.once(f)
+		return nil
+	}
 
-	timeout := time.After(gen.duration + gen.warmup)
-	warmed := time.Now().Add(gen.warmup)
+	timeout := after(gen.clock, gen.duration+gen.warmup)
+	cancelled := gen.Context().Done()
+	warmed := gen.now().Add(gen.warmup)
+	ok := gen.ok(0)
 
 	for {
+		start := gen.now()
+		gen.op(f, ok, start, warmed)
+		if timeout == nil && gen.enough() {
+			return nil
+		}
+
 		select {
-		case start := <-ticker.C:
-			err := f()
-			if start.After(warmed) {
-				if err == nil {
-					// record success
-					elapsed := us(time.Now().Sub(start))
-					if err := gen.hist.RecordCorrectedValue(elapsed, us(gen.period)); err != nil {
-						log.Println(err)
-					}
-					atomic.AddUint64(gen.success, 1)
-				} else {
-					// record failure
-					atomic.AddUint64(gen.failure, 1)
-				}
+		case <-after(gen.clock, interval-gen.now().Sub(start)):
+		case <-cancelled:
+			return nil
+		case <-timeout:
+			if gen.enough() {
+				return nil
 			}
+			timeout = nil
+		}
+	}
+}
+
+// ok returns a function which records successful operations, correcting their
+// latencies for coordinated omission at the given interval in µs.
+func (gen *Generator) ok(interval int64) func(start, end time.Time) {
+	return func(start, end time.Time) {
+		if end.IsZero() {
+			gen.succeeded()
+			return
+		}
+
+		gen.succeed(us(end.Sub(start)), interval)
+		gen.recordSplits()
+	}
+}
+
+// DoConnect generates load using the given function, which is expected to
+// establish (and close) a connection. Successful operations are counted in
+// Result.Connects rather than Result.Success, and their latencies are recorded
+// in Result.ConnectLatency rather than Result.Latency. Failures are counted in
+// Result.Failure, as with Do.
+func (gen *Generator) DoConnect(f func() error) error {
+	return gen.loop(f, func(start, end time.Time) {
+		atomic.AddUint64(&gen.counts.connects, 1)
+		atomic.AddInt64(&gen.count, 1)
+		if end.IsZero() {
+			return
+		}
+
+		gen.recordIn(&gen.connHist, us(end.Sub(start)), gen.interval(), 1)
+	})
+}
+
+// DoStream generates load using the given function, which is expected to
+// perform a streaming request which produces many events. The function must
+// call record with the latency of each event (e.g. the time since the previous
+// event), which is recorded in Result.StreamLatency. Events are recorded as
+// they arrive, even if the request as a whole later fails. The request itself
+// is counted and its latency recorded as with Do. record must only be called
+// from within the function.
+func (gen *Generator) DoStream(f func(record func(time.Duration)) error) error {
+	event := func(d time.Duration) {
+		if !gen.measured {
+			return
+		}
+
+		gen.recordIn(&gen.streamHist, us(d), 0, 1)
+	}
+
+	return gen.Do(func() error {
+		return f(event)
+	})
+}
+
+// DoServerTime generates load using the given function, which returns the
+// latency the target reported for each operation (e.g. from a response header)
+// along with its outcome. Operations are counted and timed as with Do, and the
+// latencies reported by successful operations are recorded in
+// Result.ServerLatency. The difference between the two distributions is the
+// time spent in the network and in queues.
+func (gen *Generator) DoServerTime(f func() (time.Duration, error)) error {
+	return gen.Do(func() error {
+		d, err := f()
+		if err != nil || !gen.measured {
+			return err
+		}
+
+		gen.recordIn(&gen.serverHist, us(d), 0, 1)
+		return nil
+	})
+}
+
+// DoWeightedLatency generates load using the given function, which returns
+// the cost of each operation (e.g. the size of its request) along with its
+// outcome. Operations are counted and timed as with Do, and the latencies of
+// successful operations are also recorded in Result.WeightedLatency as if
+// each had occurred as many times as its cost, so that its percentiles
+// reflect where the time went rather than how many operations there were.
+// Operations with costs of zero or less aren't recorded there, and the
+// weighted latencies aren't corrected for coordinated omission.
+func (gen *Generator) DoWeightedLatency(f func() (int64, error)) error {
+	var weight int64
+	ok := gen.ok(gen.interval())
+	return gen.loop(func() error {
+		var err error
+		weight, err = f()
+		return err
+	}, func(start, end time.Time) {
+		ok(start, end)
+		if end.IsZero() || weight <= 0 {
+			return
+		}
+
+		gen.recordIn(&gen.weightedHist, us(end.Sub(start)), 0, weight)
+	})
+}
+
+// DoBatch generates load using the given function, which is expected to
+// perform a batch operation which processes many items. The function must call
+// record with the latency of each item (e.g. the batch's latency divided
+// by the number of items, or a per-item timing reported by the target). Each
+// batch is counted in Result.Success or Result.Failure, and its latency
+// recorded in Result.Latency, as with Do; the items of successful batches
+// are counted in Result.Items and their latencies recorded in
+// Result.ItemLatency. record must only be called from within the function.
+func (gen *Generator) DoBatch(f func(record func(time.Duration)) error) error {
+	item := func(d time.Duration) {
+		gen.batch = append(gen.batch, us(d))
+	}
+
+	return gen.Do(func() error {
+		gen.batch = gen.batch[:0]
+		err := f(item)
+		if err != nil || !gen.measured {
+			return err
+		}
+
+		for _, v := range gen.batch {
+			gen.recordIn(&gen.itemHist, v, 0, 1)
+		}
+		atomic.AddUint64(&gen.counts.items, uint64(len(gen.batch)))
+		return nil
+	})
+}
+
+// DoStatus generates load using the given function, which returns a status
+// code (e.g. an HTTP status) for each operation. The number of operations with
+// each non-zero status is recorded in Result.StatusCounts. If the bench has a
+// SuccessStatus function, operations whose status it rejects are counted as
+// application failures; otherwise, only operations which return an error are
+// counted as failures.
+func (gen *Generator) DoStatus(f func() (int, error)) error {
+	return gen.Do(func() error {
+		return gen.status(f())
+	})
+}
+
+// status records the status code of an operation, returning its error, or an
+// AppError if the bench's SuccessStatus rejects the status code.
+func (gen *Generator) status(status int, err error) error {
+	if gen.measured && status != 0 {
+		if gen.statuses == nil {
+			gen.statuses = make(map[int]int)
+		}
+		gen.statuses[status]++
+	}
+
+	if err == nil && gen.successStatus != nil && !gen.successStatus(status) {
+		return AppError(fmt.Errorf("unsuccessful status: %d", status))
+	}
+	return err
+}
+
+// loop performs the given function on every tick of the generator's period
+// until the run is over, calling ok for each operation which succeeds after
+// the warmup period.
+func (gen *Generator) loop(f func() error, ok func(start, end time.Time)) error {
+	if err := gen.begin(); err != nil {
+		return err
+	}
+
+	if gen.preflight {
+		gen.once(f)
+		return nil
+	}
+
+	timeout := after(gen.clock, gen.duration+gen.warmup)
+	cancelled := gen.Context().Done()
+	warmed := gen.now().Add(gen.warmup)
+
+	if gen.barrier != nil {
+		// released workers fire immediately, rather than on their first tick
+		gen.op(f, ok, gen.now(), warmed)
+	}
+
+	if gen.stagger {
+		select {
+		case <-after(gen.clock, time.Duration(gen.rand.Int63n(int64(gen.period)))):
+		case <-cancelled:
+			return nil
 		case <-timeout:
+			if gen.enough() {
+				return nil
+			}
+			timeout = nil
+		}
+	}
+
+	if gen.arrivals == PoissonArrivals {
+		// operations are scheduled at exponentially-distributed intervals, and
+		// timed from when they were due, so a backlog is reflected in latency
+		due := gen.now().Add(gen.arrival())
+		timer := gen.clock.NewTimer(due.Sub(gen.now()))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C():
+				gen.op(f, ok, due, warmed)
+				if timeout == nil && gen.enough() {
+					return nil
+				}
+				due = due.Add(gen.arrival())
+				timer.Reset(due.Sub(gen.now()))
+			case <-cancelled:
+				return nil
+			case <-timeout:
+				if gen.enough() {
+					return nil
+				}
+				timeout = nil
+			}
+		}
+	}
+
+	ticker := gen.clock.NewTicker(gen.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case start := <-ticker.C():
+			gen.op(f, ok, start, warmed)
+			if timeout == nil && gen.enough() {
+				return nil
+			}
+		case <-cancelled:
 			return nil
+		case <-timeout:
+			if gen.enough() {
+				return nil
+			}
+			timeout = nil
+		}
+	}
+}
+
+// arrival returns an exponentially-distributed interval between operations,
+// with a mean of the generator's period.
+func (gen *Generator) arrival() time.Duration {
+	return time.Duration(gen.rand.ExpFloat64() * float64(gen.period))
+}
+
+// enough returns true if the generator has recorded at least the bench's
+// MinOps operations, so it can stop once the run is over.
+func (gen *Generator) enough() bool {
+	return atomic.LoadInt64(&gen.count) >= int64(gen.minOps)
+}
+
+// interval returns the interval in µs at which operations are expected to be
+// issued, for correcting latencies for coordinated omission. Poisson arrivals
+// are timed from when they were due, so their latencies need no correction.
+func (gen *Generator) interval() int64 {
+	if gen.arrivals == PoissonArrivals {
+		return 0
+	}
+	return us(gen.period)
+}
+
+// op performs a single operation which was scheduled to start at start. If the
+// operation succeeds but was not sampled for latency, ok is called with a zero
+// end time.
+func (gen *Generator) op(f func() error, ok func(start, end time.Time), start, warmed time.Time) {
+	gen.pending = gen.pending[:0]
+	gen.seq++
+	gen.measured = start.After(warmed) && gen.seq > int64(gen.warmupOps)
+	if gen.debug {
+		defer gen.checkOp(atomic.LoadInt64(&gen.count))
+	}
+
+	if gen.sample > 1 && gen.seq%int64(gen.sample) != 0 {
+		err := f()
+		if gen.abandoned(err) {
+			gen.measured = false
+		}
+		if gen.measured {
+			if err == nil {
+				ok(start, time.Time{})
+			} else {
+				gen.fail(err)
+			}
+			gen.observe(start, 0, err)
+		}
+		return
+	}
+
+	began := gen.now()
+	gen.mark = began
+	err := f()
+	end := gen.clamp(start, gen.now())
+	if gen.abandoned(err) {
+		gen.measured = false
+	}
+
+	// if the previous operation overran this one's start, the delay is the
+	// target's doing, not the scheduler's
+	due := start
+	if gen.last.After(due) {
+		due = gen.last
+	}
+	gen.last = end
+
+	if gen.measured {
+		if gen.sample > 1 {
+			gen.busy += end.Sub(began) * time.Duration(gen.sample)
+		} else {
+			gen.busy += end.Sub(began)
+			gen.delay += began.Sub(due)
+			gen.delays++
+		}
+
+		if err == nil {
+			ok(start, end)
+		} else {
+			gen.fail(err)
+		}
+		gen.observe(start, end.Sub(start), err)
+	}
+}
+
+// observe passes the outcome of an operation to the bench's OnOperation
+// callback, if it has one.
+func (gen *Generator) observe(start time.Time, latency time.Duration, err error) {
+	if gen.onOp != nil {
+		gen.onOp(OpResult{Worker: gen.id, Start: start, Latency: latency, Err: err})
+	}
+}
+
+// An OpResult is the outcome of a single operation, as passed to a bench's
+// OnOperation callback.
+type OpResult struct {
+	Worker  int           // the id of the worker which performed the operation
+	Start   time.Time     // when the operation was scheduled to start
+	Latency time.Duration // zero if the operation wasn't sampled
+	Err     error         // the error the operation returned, if any
+}
+
+// now returns the current time, from the generator's clock if it has one. Its
+// result includes a monotonic clock reading, so latencies computed from it are
+// unaffected by changes to the wall clock.
+func (gen *Generator) now() time.Time {
+	if gen.clock != nil {
+		return gen.clock.Now()
+	}
+	return time.Now()
+}
+
+// clamp returns end, unless it is not after start, in which case the clock has
+// misbehaved and the end of the shortest recordable operation is returned
+// instead.
+func (gen *Generator) clamp(start, end time.Time) time.Time {
+	if end.After(start) {
+		return end
+	}
+
+	atomic.AddUint64(&gen.counts.anomalies, 1)
+
+	min := time.Duration(gen.min) * time.Microsecond
+	if min < time.Microsecond {
+		min = time.Microsecond
+	}
+	return start.Add(min)
+}
+
+// The states of a generator's job.
+const (
+	inSetup int32 = iota
+	running
+	returned
+	timedOut
+)
+
+// ErrSetupTimeout is returned by Generator.Do and its variants when they are
+// called by a job whose setup took longer than the bench's SetupTimeout.
+var ErrSetupTimeout = errors.New("buster: job setup timed out")
+
+// begin marks the generator's job as having started generating load, and waits
+// for the other workers if necessary.
+func (gen *Generator) begin() error {
+	if !atomic.CompareAndSwapInt32(&gen.state, inSetup, running) &&
+		atomic.LoadInt32(&gen.state) == timedOut {
+		return ErrSetupTimeout
+	}
+
+	gen.sync()
+	return nil
+}
+
+// sync waits for all of the run's workers to be ready to generate load, if the
+// bench has SyncStart set.
+func (gen *Generator) sync() {
+	if gen.barrier != nil {
+		gen.arrive.Do(gen.barrier.Done)
+		gen.barrier.Wait()
+	}
+}
+
+// Split records the time since the start of the current operation, or since
+// the previous call to Split, as the latency of the named sub-step of the
+// operation. It must only be called from within the function passed to Do.
+//
+// Sub-step latencies are recorded in Result.Splits, but only if the operation
+// as a whole succeeds: a sub-step fails by causing the operation to return an
+// error, which counts as a single failure and discards all of the operation's
+// splits.
+func (gen *Generator) Split(name string) {
+	now := gen.now()
+	gen.pending = append(gen.pending, split{name: name, elapsed: us(now.Sub(gen.mark))})
+	gen.mark = now
+}
+
+type split struct {
+	name    string
+	elapsed int64
+}
+
+func (gen *Generator) recordSplits() {
+	for _, s := range gen.pending {
+		if gen.splits == nil {
+			gen.splits = make(map[string]*hdrhistogram.Histogram)
 		}
+
+		h := gen.splits[s.name]
+		gen.recordIn(&h, s.elapsed, 0, 1)
+		gen.splits[s.name] = h
 	}
 }
 
+// Partition returns the partition of the generator's worker, if the bench has
+// Partitions. Jobs can use it to confine each worker to its own partition of
+// the keyspace, as a sharded client would be.
+func (gen *Generator) Partition() int {
+	return gen.partition
+}
+
+// Rand returns the generator's random source, for jobs which make random
+// choices (e.g. of think time or of request). It is seeded from the bench's
+// Seed, if it has one, and is not safe for concurrent use.
+func (gen *Generator) Rand() *rand.Rand {
+	return gen.rand
+}
+
+// Context returns the context of the generator's run, which is done once the
+// run has been cancelled (see Bench.RunContext). Jobs may pass it to their
+// operations so that cancelling the run abandons them as well.
+func (gen *Generator) Context() context.Context {
+	if gen.ctx == nil {
+		return context.Background()
+	}
+	return gen.ctx
+}
+
+// abandoned returns true if err is due to the cancellation of the generator's
+// run, once it has been cancelled.
+func (gen *Generator) abandoned(err error) bool {
+	if err == nil || gen.ctx == nil || gen.ctx.Err() == nil {
+		return false
+	}
+	if gen.cancelledErr != nil {
+		return gen.cancelledErr(err)
+	}
+	return errors.Is(err, gen.ctx.Err())
+}
+
+// Probe returns true if the generator belongs to one of the bench's probe
+// workers.
+func (gen *Generator) Probe() bool {
+	return gen.probe
+}
+
+// Count returns the number of operations this generator has recorded so far.
+// Operations performed during the warmup period are not counted.
+func (gen *Generator) Count() int64 {
+	return atomic.LoadInt64(&gen.count)
+}
+
+// Record records an operation which was performed and timed elsewhere, e.g.
+// by a subprocess or a remote agent, with the given latency and outcome. It is
+// for jobs which aggregate externally-executed work rather than calling one of
+// the Do methods, and which drive their own loop: such a job should return
+// once the generator's Deadline has passed or its Context is done. Operations
+// recorded before the warmup period is over are not counted.
+func (gen *Generator) Record(latency time.Duration, err error) {
+	if atomic.LoadInt32(&gen.state) == inSetup && gen.begin() != nil {
+		return
+	}
+
+	end := gen.now()
+	gen.seq++
+	if !end.After(gen.start.Add(gen.warmup)) || gen.seq <= int64(gen.warmupOps) ||
+		gen.abandoned(err) {
+		return
+	}
+
+	if err == nil {
+		gen.succeed(us(latency), 0)
+	} else {
+		gen.fail(err)
+	}
+	gen.observe(end.Add(-latency), latency, err)
+}
+
+// Deadline returns the time at which the run is due to end.
+func (gen *Generator) Deadline() time.Time {
+	return gen.start.Add(gen.warmup + gen.duration)
+}
+
+// succeed records a successful operation which took elapsed µs. If interval is
+// non-zero, the latency is corrected for coordinated omission.
+func (gen *Generator) succeed(elapsed, interval int64) {
+	if elapsed <= gen.min {
+		atomic.AddUint64(&gen.counts.underflow, 1)
+	} else if elapsed > gen.max && !gen.autoMax {
+		atomic.AddUint64(&gen.counts.overflow, 1)
+	}
+
+	var count int64
+	if gen.debug {
+		gen.checkRecording()
+		count = gen.hist.TotalCount()
+	}
+
+	if err := record(gen.hist, elapsed, interval); err != nil {
+		if gen.widen {
+			gen.overflow = append(gen.overflow, overflow{elapsed, interval})
+		} else {
+			log.Println(err)
+		}
+	} else if gen.debug {
+		gen.checkRecorded(count)
+	}
+	gen.succeeded()
+}
+
+// succeeded counts a successful operation without recording its latency.
+func (gen *Generator) succeeded() {
+	atomic.AddUint64(&gen.counts.success, 1)
+	atomic.AddUint64(&gen.successes, 1)
+	atomic.AddInt64(&gen.count, 1)
+}
+
+// fail records a failed operation.
+func (gen *Generator) fail(err error) {
+	if gen.debug {
+		gen.checkRecording()
+	}
+	if gen.errs != nil {
+		gen.errs.add(err)
+	}
+	if gen.partitionErrs != nil {
+		gen.partitionErrs.add(err)
+	}
+	atomic.AddUint64(&gen.counts.failure, 1)
+	atomic.AddUint64(&gen.failures, 1)
+	atomic.AddInt64(&gen.count, 1)
+	if fdExhausted(err) {
+		atomic.AddUint64(&gen.counts.fdExhausted, 1)
+	}
+	if IsAppError(err) {
+		atomic.AddUint64(&gen.counts.appFailure, 1)
+		atomic.AddUint64(&gen.appFailures, 1)
+	} else {
+		atomic.AddUint64(&gen.counts.netFailure, 1)
+		atomic.AddUint64(&gen.netFailures, 1)
+	}
+}
+
+// record records a latency of elapsed µs in h. If interval is non-zero, the
+// latency is corrected for coordinated omission.
+func record(h *hdrhistogram.Histogram, elapsed, interval int64) error {
+	if interval > 0 {
+		return h.RecordCorrectedValue(elapsed, interval)
+	}
+	return h.RecordValue(elapsed)
+}
+
+// recordIn records n latencies of elapsed µs in *h, creating it with the
+// generator's bounds if it's nil. If the generator widens latencies, *h is
+// widened to fit latencies which are too large for it; otherwise they're
+// logged and dropped. If interval is non-zero, a single latency is corrected
+// for coordinated omission.
+func (gen *Generator) recordIn(h **hdrhistogram.Histogram, elapsed, interval, n int64) {
+	if *h == nil {
+		*h = hdrhistogram.New(gen.min, gen.max, 5)
+	}
+
+	rec := func() error {
+		if n > 1 {
+			return (*h).RecordValues(elapsed, n)
+		}
+		return record(*h, elapsed, interval)
+	}
+
+	err := rec()
+	if err != nil && gen.widen && elapsed > (*h).HighestTrackableValue() {
+		*h = mergeInto(hdrhistogram.New((*h).LowestTrackableValue(), elapsed, int((*h).SignificantFigures())), *h)
+		err = rec()
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// An overflow is a latency which was too large to be recorded.
+type overflow struct {
+	elapsed, interval int64
+}
+
+// widen returns h, or if there are any overflows, a copy of h which is wide
+// enough to record them in as well.
+func widen(h *hdrhistogram.Histogram, overflows []overflow) *hdrhistogram.Histogram {
+	if len(overflows) == 0 {
+		return h
+	}
+
+	max := h.HighestTrackableValue()
+	for _, o := range overflows {
+		if o.elapsed > max {
+			max = o.elapsed
+		}
+	}
+
+	w := hdrhistogram.New(h.LowestTrackableValue(), max, int(h.SignificantFigures()))
+	w.Merge(h)
+	for _, o := range overflows {
+		if err := record(w, o.elapsed, o.interval); err != nil {
+			log.Println(err)
+		}
+	}
+	return w
+}
+
+// counters are the operation counts shared by all of a run's generators.
+type counters struct {
+	success, failure, appFailure, netFailure uint64
+	connects, anomalies                      uint64
+	underflow, overflow, items, fdExhausted  uint64
+}
+
+// load copies the counts into r. Workers which are still running may be
+// updating the counts, so they are read atomically.
+func (c *counters) load(r *Result) {
+	r.Success = atomic.LoadUint64(&c.success)
+	r.Failure = atomic.LoadUint64(&c.failure)
+	r.AppFailures = atomic.LoadUint64(&c.appFailure)
+	r.TransportFailures = atomic.LoadUint64(&c.netFailure)
+	r.Connects = atomic.LoadUint64(&c.connects)
+	r.ClockAnomalies = atomic.LoadUint64(&c.anomalies)
+	r.Underflow = atomic.LoadUint64(&c.underflow)
+	r.Overflow = atomic.LoadUint64(&c.overflow)
+	r.Items = atomic.LoadUint64(&c.items)
+}
+
+// A StopReason is why a run ended.
+type StopReason int
+
+const (
+	// StopCompleted means the run lasted its full duration, or replayed its
+	// full trace.
+	StopCompleted StopReason = iota
+
+	// StopShutdownTimeout means the run ended because the bench's
+	// ShutdownTimeout expired while some workers were still running.
+	StopShutdownTimeout
+
+	// StopTraceError means a replay ended early because its trace returned
+	// an error.
+	StopTraceError
+
+	// StopUnreachable means the run didn't start because the bench's
+	// preflight check found the target to be unreachable.
+	StopUnreachable
+
+	// StopCancelled means the run ended early because the context passed to
+	// RunContext was cancelled.
+	StopCancelled
+
+	// StopDeadline means the run ended early because the deadline of the
+	// context passed to RunContext expired.
+	StopDeadline
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case StopCompleted:
+		return "completed"
+	case StopShutdownTimeout:
+		return "shutdown timeout"
+	case StopTraceError:
+		return "trace error"
+	case StopUnreachable:
+		return "unreachable"
+	case StopCancelled:
+		return "cancelled"
+	case StopDeadline:
+		return "deadline exceeded"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(s))
+}
+
+// AppError marks err as an application failure (e.g. an HTTP 503) rather
+// than a transport failure (e.g. a refused connection). Operations which
+// return an AppError are counted in Result.AppFailures; all other failed
+// operations are counted in Result.TransportFailures.
+func AppError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return appError{err: err}
+}
+
+// IsAppError returns true if err, or any error it wraps, was marked with
+// AppError.
+func IsAppError(err error) bool {
+	var ae appError
+	return errors.As(err, &ae)
+}
+
+type appError struct {
+	err error
+}
+
+func (e appError) Error() string {
+	return e.err.Error()
+}
+
+func (e appError) Unwrap() error {
+	return e.err
+}
+
 // A Result is returned after a number of concurrent jobs are run.
+//
+// Start and End are the times at which measurement began (i.e. after the
+// warmup period) and ended. Failure is always the sum of TransportFailures and
+// AppFailures.
+//
+// Latency records the latency of successful operations in µs. It is corrected
+// for coordinated omission: if an operation takes longer than a worker's
+// period, the operations which should have been issued in the meantime are
+// recorded too. String and every other reporting helper read from Latency, so
+// reported percentiles are always the corrected ones.
 type Result struct {
-	Concurrency      int
-	Elapsed          time.Duration
-	Success, Failure uint64
-	Latency          *hdrhistogram.Histogram
-	Errors           []error
+	Concurrency                    int
+	Start, End                     time.Time
+	Elapsed                        time.Duration
+	Success, Failure               uint64
+	TransportFailures, AppFailures uint64
+	Latency                        *hdrhistogram.Histogram
+
+	// Errors are the errors returned by jobs after they started generating
+	// load, sorted by message so that they are in the same order from run to
+	// run.
+	Errors []error
+
+	// SetupFailures is the number of jobs which returned an error without
+	// ever generating load (e.g. because they failed to authenticate).
+	// Rather than keeping every such error, SetupError is the first of them
+	// and SetupErrorCounts is the number of times each distinct error message
+	// occurred.
+	SetupFailures    int
+	SetupError       error
+	SetupErrorCounts map[string]int
+
+	// ErrorCounts is the number of failed operations which returned each
+	// distinct error message, and ErrorSamples is a uniform random sample of
+	// their errors, of up to the bench's RetainErrors. Both are nil unless
+	// RetainErrors is set.
+	ErrorCounts  map[string]int
+	ErrorSamples []error
+	retain       int
+
+	// Connects is the number of connections established by
+	// Generator.DoConnect, and ConnectLatency records how long they took. If
+	// DoConnect was not used, ConnectLatency is nil.
+	Connects       uint64
+	ConnectLatency *hdrhistogram.Histogram
+
+	// StreamLatency records the latencies of the events of streaming requests
+	// made with Generator.DoStream. If DoStream was not used, it is nil.
+	StreamLatency *hdrhistogram.Histogram
+
+	// Items is the number of items processed by the successful batch
+	// operations performed with Generator.DoBatch, and ItemLatency
+	// records their latencies. Batches are counted as single operations in
+	// Success and Failure, so Success is the batch throughput and Items the
+	// item throughput. If DoBatch was not used, ItemLatency is nil.
+	Items       uint64
+	ItemLatency *hdrhistogram.Histogram
+
+	// ServerLatency records the latencies reported by the target for
+	// successful operations performed with Generator.DoServerTime. If
+	// DoServerTime was not used, it is nil.
+	ServerLatency *hdrhistogram.Histogram
+
+	// WeightedLatency records the latencies of successful operations
+	// performed with Generator.DoWeightedLatency, each counted as many times
+	// as its cost. If DoWeightedLatency was not used, it is nil.
+	WeightedLatency *hdrhistogram.Histogram
+
+	// ProbeLatency records the latencies of the operations performed by the
+	// bench's probe workers, which are not included in Latency. If the bench
+	// has no probes, it is nil.
+	ProbeLatency *hdrhistogram.Histogram
+
+	// StatusCounts is the number of operations performed with
+	// Generator.DoStatus which returned each status code.
+	StatusCounts map[int]int
+
+	// Metric is the combined custom metric of operations performed with
+	// DoMetric, or nil if DoMetric was not used.
+	Metric interface{}
+	reduce func(a, b interface{}) interface{}
+
+	// Partitions is the results of the workers in each of the bench's
+	// Partitions, indexed by partition. Each has the counts, latencies, and
+	// errors of its workers' operations, and the Concurrency and Elapsed of
+	// the whole run. It is nil if the bench has no Partitions.
+	Partitions []Result
+
+	// Tagged is the results of the operations performed with
+	// Generator.DoTagged, keyed by their tags (see TagKey). Each has the
+	// counts and latencies of its operations, and the Concurrency and
+	// Elapsed of the whole run.
+	Tagged map[string]*Result
+
+	// Splits records the latencies of the named sub-steps of successful
+	// operations, as marked by Generator.Split.
+	Splits map[string]*hdrhistogram.Histogram
+
+	// Warmup is how long the target took to reach a steady state before the
+	// run was measured, as detected by Bench.RunConverged. It is zero for
+	// other runs.
+	Warmup time.Duration
+
+	// TargetRate is the rate, in operations per second, which the run's
+	// workers were asked to perform operations at, and AchievedRate is the
+	// rate at which they actually performed them, successfully or not. If
+	// the achieved rate falls well short of the target (see RateDiverges),
+	// the workers couldn't keep up, usually because the target's latency
+	// exceeded their periods, and the latencies should be read with that in
+	// mind.
+	TargetRate, AchievedRate float64
+
+	// EffectiveConcurrency is the average number of operations in progress
+	// over the run: the total time spent performing operations divided by
+	// Elapsed. If it is much lower than Concurrency, the workers spent most
+	// of the run idle and the target was not under the intended pressure.
+	EffectiveConcurrency float64
+
+	// SchedulingDelay is the mean time between when operations were due to
+	// start and when they actually started, excluding any delay caused by a
+	// worker's previous operation overrunning. GeneratorSaturated is set if
+	// the delay exceeds a tenth of a worker's period, which usually means the
+	// load generator is short of CPU and the measured latencies include
+	// client-side queueing.
+	SchedulingDelay    time.Duration
+	GeneratorSaturated bool
+
+	// ClockAnomalies is the number of operations which appeared to take no
+	// time at all, or less, because of a misbehaving clock. Their latencies
+	// are recorded as MinLatency.
+	ClockAnomalies uint64
+
+	// Underflow is the number of successful operations whose latencies were
+	// at or below MinLatency, and Overflow the number whose latencies were
+	// above MaxLatency, if it's set. Both should be zero; if they aren't, the
+	// histogram's bounds don't bracket the measured latencies, and the
+	// fastest or slowest percentiles are pinned at the bounds (unless
+	// WidenLatency is set, in which case overflows are recorded after all).
+	Underflow, Overflow uint64
+
+	// GeneratorCPU is the CPU time used by the process during the run, as a
+	// percentage of one CPU (so it may exceed 100), and GeneratorHeap is the
+	// largest heap size sampled during the run, in bytes. They are only
+	// measured if the bench has SampleResources set. If either is high, the
+	// load generator may have been the bottleneck, and the measured
+	// latencies should be treated with suspicion. GeneratorCPU is zero on
+	// platforms which don't report CPU time.
+	GeneratorCPU  float64
+	GeneratorHeap uint64
+
+	// ResourceLimited is true if the run was likely limited by the load
+	// generator's resources rather than by the target, so that some of its
+	// failures (typically "too many open files" errors, counted as
+	// transport failures) are not the target's. ResourceLimit says why:
+	// operations failed because the process ran out of file descriptors, or
+	// the bench's FDsPerWorker implied that the concurrency level would
+	// need more than the process's limit.
+	ResourceLimited bool
+	ResourceLimit   string
+
+	// StopReason is why the run ended.
+	StopReason StopReason
+
+	// StuckWorkers is the number of workers which had not returned when the
+	// bench's ShutdownTimeout expired. Their measurements are not included
+	// in Latency or Errors, though any operations they completed are counted.
+	StuckWorkers int
+}
+
+// Add folds the counts (including StuckWorkers), errors, and latency
+// measurements of other into r. The Concurrency and Elapsed fields of r are
+// left unchanged.
+func (r *Result) Add(other Result) {
+	r.Success += other.Success
+	r.Failure += other.Failure
+	r.TransportFailures += other.TransportFailures
+	r.AppFailures += other.AppFailures
+	r.Errors = append(r.Errors, other.Errors...)
+	sortErrors(r.Errors)
+	r.addSetupErrors(other.SetupError, other.SetupFailures, other.SetupErrorCounts)
+	r.addErrorSamples(other)
+	r.Connects += other.Connects
+	r.ClockAnomalies += other.ClockAnomalies
+	r.Underflow += other.Underflow
+	r.Overflow += other.Overflow
+	r.StuckWorkers += other.StuckWorkers
+	r.addStatuses(other.StatusCounts)
+	r.addMetric(other.Metric, other.reduce)
+	r.addSplits(other.Splits)
+	r.addTagged(other.Tagged)
+	r.addPartitions(other.Partitions)
+	r.Latency = mergeInto(r.Latency, other.Latency)
+	r.ConnectLatency = mergeInto(r.ConnectLatency, other.ConnectLatency)
+	r.StreamLatency = mergeInto(r.StreamLatency, other.StreamLatency)
+	r.ServerLatency = mergeInto(r.ServerLatency, other.ServerLatency)
+	r.WeightedLatency = mergeInto(r.WeightedLatency, other.WeightedLatency)
+	r.Items += other.Items
+	r.ItemLatency = mergeInto(r.ItemLatency, other.ItemLatency)
+	r.ProbeLatency = mergeInto(r.ProbeLatency, other.ProbeLatency)
+	if other.ResourceLimited {
+		r.limitedBy(other.ResourceLimit)
+	}
+}
+
+// RateDiverges returns true if the result's achieved rate differs from its
+// target rate by more than the given proportion of the target (e.g. 0.1 for
+// 10%).
+func (r Result) RateDiverges(tolerance float64) bool {
+	if r.TargetRate == 0 {
+		return false
+	}
+	return math.Abs(r.AchievedRate-r.TargetRate) > tolerance*r.TargetRate
+}
+
+// Summarize combines the given results, e.g. those of a series of runs at
+// increasing concurrency levels, into a single aggregate result. Counts and
+// errors are totalled, and the latency histograms are merged, so the aggregate
+// latency distribution weights each result by the number of operations it
+// recorded. The aggregate's Concurrency is the highest of the results', its
+// Elapsed is their total, and its StopReason is that of the first result which
+// didn't complete. Its SchedulingDelay is the largest of the results', and it
+// is GeneratorSaturated if any of them were.
+func Summarize(results []Result) Result {
+	var total Result
+	var busy float64
+
+	for i, r := range results {
+		total.Add(r)
+
+		if r.Concurrency > total.Concurrency {
+			total.Concurrency = r.Concurrency
+		}
+		if i == 0 || r.Start.Before(total.Start) {
+			total.Start = r.Start
+		}
+		if r.End.After(total.End) {
+			total.End = r.End
+		}
+		if total.StopReason == StopCompleted {
+			total.StopReason = r.StopReason
+		}
+		if r.SchedulingDelay > total.SchedulingDelay {
+			total.SchedulingDelay = r.SchedulingDelay
+		}
+		total.GeneratorSaturated = total.GeneratorSaturated || r.GeneratorSaturated
+
+		total.Elapsed += r.Elapsed
+		busy += r.EffectiveConcurrency * float64(r.Elapsed)
+	}
+
+	if total.Elapsed > 0 {
+		total.EffectiveConcurrency = busy / float64(total.Elapsed)
+	}
+
+	return total
+}
+
+// sortErrors sorts errors by message, keeping errors with the same message in
+// order.
+func sortErrors(errs []error) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+}
+
+// mergeInto merges from into h, returning h. If h is nil, a copy of from is
+// returned instead, and if from has wider bounds than h (e.g. because one of
+// them was widened), a histogram wide enough for both is returned.
+func mergeInto(h, from *hdrhistogram.Histogram) *hdrhistogram.Histogram {
+	if from == nil {
+		return h
+	}
+
+	if h == nil {
+		return hdrhistogram.Import(from.Export())
+	}
+
+	if from.LowestTrackableValue() < h.LowestTrackableValue() ||
+		from.HighestTrackableValue() > h.HighestTrackableValue() ||
+		from.SignificantFigures() > h.SignificantFigures() {
+		min, max, sigfigs := h.LowestTrackableValue(), h.HighestTrackableValue(), h.SignificantFigures()
+		if v := from.LowestTrackableValue(); v < min {
+			min = v
+		}
+		if v := from.HighestTrackableValue(); v > max {
+			max = v
+		}
+		if v := from.SignificantFigures(); v > sigfigs {
+			sigfigs = v
+		}
+
+		w := hdrhistogram.New(min, max, int(sigfigs))
+		mustMerge(w, h)
+		h = w
+	}
+
+	mustMerge(h, from)
+	return h
+}
+
+// mustMerge merges from into h, which must be wide enough to hold all of its
+// values.
+func mustMerge(h, from *hdrhistogram.Histogram) {
+	if dropped := h.Merge(from); dropped > 0 {
+		panic(fmt.Sprintf("buster: merging latencies dropped %d values", dropped))
+	}
+}
+
+func (r *Result) addSetupErrors(first error, n int, counts map[string]int) {
+	if r.SetupError == nil {
+		r.SetupError = first
+	}
+	r.SetupFailures += n
+
+	for msg, n := range counts {
+		if r.SetupErrorCounts == nil {
+			r.SetupErrorCounts = make(map[string]int)
+		}
+		r.SetupErrorCounts[msg] += n
+	}
+}
+
+func (r *Result) addPartitions(partitions []Result) {
+	for i, p := range partitions {
+		if i == len(r.Partitions) {
+			r.Partitions = append(r.Partitions, Result{Concurrency: p.Concurrency, Elapsed: p.Elapsed})
+		}
+		r.Partitions[i].Add(p)
+	}
+}
+
+func (r *Result) addStatuses(statuses map[int]int) {
+	for status, n := range statuses {
+		if r.StatusCounts == nil {
+			r.StatusCounts = make(map[int]int)
+		}
+		r.StatusCounts[status] += n
+	}
+}
+
+func (r *Result) addSplits(splits map[string]*hdrhistogram.Histogram) {
+	for name, h := range splits {
+		if r.Splits == nil {
+			r.Splits = make(map[string]*hdrhistogram.Histogram)
+		}
+		r.Splits[name] = mergeInto(r.Splits[name], h)
+	}
+}
+
+// Percentile returns the latency at the given quantile (0..100) of successful
+// operations, as resolved by the latency histogram. It is zero if the result
+// has no latency histogram.
+func (r Result) Percentile(q float64) time.Duration {
+	if r.Latency == nil {
+		return 0
+	}
+	return time.Duration(r.Latency.ValueAtQuantile(q)) * time.Microsecond
+}
+
+// InterpolatedPercentile returns an approximation of the latency at the given
+// quantile (0..100), by interpolating linearly between the latencies of the
+// recorded operations which rank either side of it. This gives smoother curves
+// when plotting percentiles across runs than Percentile, which jumps between
+// recorded values, but the values it returns may never have been measured, so
+// it should be used for plotting only, not for checking latency budgets.
+func (r Result) InterpolatedPercentile(q float64) time.Duration {
+	if r.Latency == nil || r.Latency.TotalCount() == 0 {
+		return 0
+	}
+
+	rank := math.Min(math.Max(q, 0), 100) / 100 * float64(r.Latency.TotalCount()-1)
+	lo := int64(rank)
+
+	// find the values of the operations ranked lo and lo+1
+	var seen int64
+	var below, above int64 = -1, -1
+	for _, bar := range r.Latency.Distribution() {
+		seen += bar.Count
+		if below < 0 && seen > lo {
+			below = bar.To
+		}
+		if seen > lo+1 {
+			above = bar.To
+			break
+		}
+	}
+	if above < 0 {
+		above = below
+	}
+
+	v := float64(below) + float64(above-below)*(rank-float64(lo))
+	return time.Duration(v * float64(time.Microsecond))
+}
+
+// ToMap returns the result's scalar metrics, for use with arbitrary encoders
+// and metrics clients. The keys are:
+//
+//	concurrency            the result's Concurrency
+//	elapsed                Elapsed, in seconds
+//	success, failure       the operation counts
+//	transport_failures     TransportFailures
+//	app_failures           AppFailures
+//	setup_failures         SetupFailures
+//	throughput             successful operations per second
+//	error_rate             the proportion of operations which failed
+//	p50, p90, p99, p999    latency percentiles, in µs
+//	max                    the maximum latency, in µs
+//	effective_concurrency  EffectiveConcurrency
+//	clock_anomalies        ClockAnomalies
+//	underflow, overflow    Underflow and Overflow
+//	stuck_workers          StuckWorkers
+//	stop_reason            StopReason, as a string
+func (r Result) ToMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"concurrency":           r.Concurrency,
+		"elapsed":               r.Elapsed.Seconds(),
+		"success":               r.Success,
+		"failure":               r.Failure,
+		"transport_failures":    r.TransportFailures,
+		"app_failures":          r.AppFailures,
+		"setup_failures":        r.SetupFailures,
+		"throughput":            0.0,
+		"error_rate":            0.0,
+		"effective_concurrency": r.EffectiveConcurrency,
+		"clock_anomalies":       r.ClockAnomalies,
+		"underflow":             r.Underflow,
+		"overflow":              r.Overflow,
+		"stuck_workers":         r.StuckWorkers,
+		"stop_reason":           r.StopReason.String(),
+	}
+
+	if r.Elapsed > 0 {
+		m["throughput"] = float64(r.Success) / r.Elapsed.Seconds()
+	}
+	if n := r.Success + r.Failure; n > 0 {
+		m["error_rate"] = float64(r.Failure) / float64(n)
+	}
+
+	for name, q := range map[string]float64{
+		"p50":  50,
+		"p90":  90,
+		"p99":  99,
+		"p999": 99.9,
+		"max":  100,
+	} {
+		var v int64
+		if r.Latency != nil {
+			v = r.Latency.ValueAtQuantile(q)
+		}
+		m[name] = v
+	}
+
+	return m
 }
 
 func (r Result) String() string {
+	return r.Report(ReportOptions{Precision: 3})
+}
+
+// ReportOptions control how Report formats a Result.
+type ReportOptions struct {
+	// Unit is the unit latencies are reported in. If zero, each latency is
+	// scaled to µs if it's under 1ms, ms if it's under 1s, and s otherwise.
+	Unit time.Duration
+
+	// Precision is the number of decimal places latencies are reported with.
+	Precision int
+
+	// TopErrors is the number of the most frequent errors listed, with their
+	// counts. Any further distinct errors are summarized in a single line.
+	// If zero, up to 5 errors are listed; if negative, none are.
+	TopErrors int
+}
+
+// defaultTopErrors is the number of errors listed by Report by default.
+const defaultTopErrors = 5
+
+// Report returns a human-readable summary of the result, formatted using the
+// given options.
+func (r Result) Report(opts ReportOptions) string {
 	out := bytes.NewBuffer(nil)
 
 	fmt.Fprintf(out,
-		"%d successes, %d failures, %d errors, %f ops/sec\n",
-		r.Success, r.Failure, len(r.Errors),
-		float64(r.Success)/r.Elapsed.Seconds(),
+		"%d successes, %d failures, %d errors, %d setup errors, %f ops/sec\n",
+		r.Success, r.Failure, len(r.Errors), r.SetupFailures,
+		throughput(r),
 	)
 
 	for _, b := range r.Latency.CumulativeDistribution() {
-		fmt.Fprintf(out, "p%f = %fms\n", b.Quantile, float64(b.ValueAt)/10000)
+		fmt.Fprintf(out, "p%f = %s\n", b.Quantile,
+			formatLatency(time.Duration(b.ValueAt)*time.Microsecond, opts))
+	}
+
+	top := opts.TopErrors
+	if top == 0 {
+		top = defaultTopErrors
+	}
+	var errs []ErrorCount
+	if top > 0 {
+		errs = SortErrorCounts(r.errorCounts())
+	}
+	for i, e := range errs {
+		if i == top {
+			fmt.Fprintf(out, "... and %d more errors\n", len(errs)-top)
+			break
+		}
+		fmt.Fprintf(out, "%d × %s\n", e.Count, e.Message)
 	}
 
 	return out.String()
 }
 
+// errorCounts returns the number of times each distinct error message occurred
+// in the result, whether returned by operations, by jobs, or during setup.
+func (r Result) errorCounts() map[string]int {
+	counts := make(map[string]int)
+	for msg, n := range r.ErrorCounts {
+		counts[msg] += n
+	}
+	for msg, n := range r.SetupErrorCounts {
+		counts[msg] += n
+	}
+	for _, err := range r.Errors {
+		counts[err.Error()]++
+	}
+	return counts
+}
+
+func formatLatency(d time.Duration, opts ReportOptions) string {
+	unit := opts.Unit
+	if unit == 0 {
+		switch {
+		case d < time.Millisecond:
+			unit = time.Microsecond
+		case d < time.Second:
+			unit = time.Millisecond
+		default:
+			unit = time.Second
+		}
+	}
+
+	var name string
+	switch unit {
+	case time.Nanosecond:
+		name = "ns"
+	case time.Microsecond:
+		name = "µs"
+	case time.Millisecond:
+		name = "ms"
+	case time.Second:
+		name = "s"
+	default:
+		name = "x" + unit.String()
+	}
+
+	return strconv.FormatFloat(float64(d)/float64(unit), 'f', opts.Precision, 64) + name
+}
+
 // A Job is an arbitrary task.
+//
+// Each of the concurrent workers in a run is passed a unique id in the range
+// [0, concurrency), followed by the bench's Probes, if it has any, with ids in
+// [concurrency, concurrency+Probes). A run at a concurrency of zero has a
+// single worker with id 0, followed by any probes from id 1. Ids are always
+// assigned in this way, so the worker with a given id can be correlated
+// across runs at different concurrency levels.
 type Job func(id int, generator *Generator) error
 
+// Arrivals determines how each worker spaces out its operations.
+type Arrivals int
+
+const (
+	// UniformArrivals issues operations at a fixed interval.
+	UniformArrivals Arrivals = iota
+
+	// PoissonArrivals issues operations at exponentially-distributed random
+	// intervals with the same mean, approximating a Poisson process.
+	PoissonArrivals
+)
+
 // A Bench is place where jobs are done.
 type Bench struct {
 	Warmup, Duration, MinLatency, MaxLatency time.Duration
+
+	// WarmupOps is the number of operations each worker performs before its
+	// operations are recorded. If Warmup is also set, an operation is only
+	// recorded once both the warmup period and the warmup operations are
+	// over.
+	WarmupOps int
+
+	// WidenLatency, if true, records latencies greater than MaxLatency rather
+	// than discarding them. Any such latencies are recorded in a histogram
+	// widened to hold them, so Result.Latency may have a higher maximum
+	// trackable value than MaxLatency.
+	//
+	// If MaxLatency is zero, the latency histogram starts with a bound of one
+	// second and is always widened to fit, so the bound needn't be guessed up
+	// front. The cost is memory: each latency over the initial bound is kept
+	// until the end of the run, and the widened histogram grows with the
+	// logarithm of the largest latency. The other histograms, such as Splits
+	// and Tagged latencies, are widened in the same cases, as soon as a
+	// latency over their bound is recorded.
+	WidenLatency bool
+
+	// SampleRate, if greater than one, records the latency of only one in
+	// every SampleRate operations, which reduces the overhead of measurement
+	// for extremely fast operations. Every operation is still counted as a
+	// success or failure, but Result.Latency holds only the sampled
+	// latencies, so percentiles deep in the tail are estimated from fewer
+	// values. SchedulingDelay is not measured when sampling.
+	//
+	// Timing and recording an operation costs two reads of the clock and
+	// well under 100ns of bookkeeping, which is a few hundred nanoseconds in
+	// all on typical hardware (see BenchmarkGeneratorOp), most of it the
+	// clock. Sampling is only worthwhile for operations which take no more
+	// than a few microseconds.
+	SampleRate int
+
+	// SuccessStatus, if non-nil, decides whether the status code of an
+	// operation performed with Generator.DoStatus indicates success.
+	SuccessStatus func(status int) bool
+
+	// Arrivals determines how each worker spaces out its operations. Stagger,
+	// if true, delays each worker's first operation by a random fraction of
+	// its period, so that the workers' operations don't clump together.
+	Arrivals Arrivals
+	Stagger  bool
+
+	// SyncStart, if true, holds every worker at the start of Generator.Do
+	// until all of them are ready, then releases them at once so that their
+	// first operations are issued simultaneously. This only synchronizes the
+	// release of the workers, not the completion of their operations.
+	SyncStart bool
+
+	// OnOperation, if non-nil, is called with the outcome of every operation
+	// performed after the warmup period. It is called concurrently from every
+	// worker's goroutine, on the path of the operations being measured, so it
+	// must be safe for concurrent use and should return quickly.
+	OnOperation func(OpResult)
+
+	// OnWorkerStart and OnWorkerStop, if set, are called when each worker's
+	// job starts and when it returns, with the job's error, if any. They are
+	// called from the workers' goroutines, so they must be safe for
+	// concurrent use and should return quickly.
+	OnWorkerStart func(id int)
+	OnWorkerStop  func(id int, err error)
+
+	// Partitions, if non-zero, divides the workers into the given number of
+	// partitions, with the worker with id i in partition i%Partitions. Each
+	// worker can find its partition with Generator.Partition, and the
+	// results of each partition are broken out in Result.Partitions.
+	Partitions int
+
+	// Probes is the number of extra workers to run alongside the others, at
+	// the same per-worker rate, whose latencies are recorded in
+	// Result.ProbeLatency rather than Result.Latency. This measures what a
+	// single client experiences while the others keep the target busy. Probe
+	// workers have the ids following those of the others, and their
+	// operations are counted in Success and Failure as usual.
+	Probes int
+
+	// TagLimit is the maximum number of distinct combinations of tags
+	// recorded by Generator.DoTagged over a run, beyond which operations are
+	// recorded under OtherTags. If it is zero, the limit is 100.
+	TagLimit int
+
+	// RetainErrors, if non-zero, is the number of the errors returned by
+	// failed operations to keep as Result.ErrorSamples, as well as counting
+	// them by message in Result.ErrorCounts. The errors kept are a uniform
+	// random sample of those of the whole run, rather than the first ones,
+	// so that they are representative of its failures.
+	RetainErrors int
+
+	// SampleResources, if true, measures the process's CPU and heap usage
+	// during the run, as Result.GeneratorCPU and Result.GeneratorHeap. The
+	// heap is sampled every 100ms, which briefly stops the world each time.
+	// Both are of the whole process, so they include the usage of any other
+	// runs in progress at the same time.
+	SampleResources bool
+
+	// FDsPerWorker, if non-zero, is the number of file descriptors each
+	// worker is expected to hold open, e.g. one for an HTTPJob with
+	// keep-alive. If a run's workers would need more in total than the
+	// process's limit on open files, Run logs a warning and marks the
+	// result as ResourceLimited. Either way, a result is marked as
+	// ResourceLimited if any operations fail for lack of file descriptors.
+	FDsPerWorker int
+
+	// LockOSThread, if true, runs each worker's job on its own OS thread, to
+	// which no other goroutine is scheduled. This keeps other goroutines in
+	// the process (e.g. those applying background load) from being run
+	// between a worker's timing calls on its thread, which helps when they
+	// are CPU-heavy and GOMAXPROCS exceeds the number of workers. It doesn't
+	// reserve CPU time: the threads still compete with the rest of the
+	// process and the machine, and the garbage collector still stops them.
+	// Goroutines started by the job are not locked.
+	LockOSThread bool
+
+	// Seed, if non-zero, seeds the workers' random sources, which are used for
+	// staggering, Poisson arrivals, and by jobs via Generator.Rand. The worker
+	// with id i is seeded with Seed+i, so each worker's sequence of random
+	// values is the same from run to run and can be replayed with RunWorker.
+	// Otherwise, the sources are seeded from the time.
+	Seed int64
+
+	// Preflight, if true, performs a single operation before a run to check
+	// that the target is reachable. If the operation fails with an error for
+	// which Unreachable returns true, the run doesn't start, and its result
+	// has the error as its SetupError and a StopReason of StopUnreachable. If Unreachable is
+	// nil, any error other than an AppError means the target is unreachable.
+	// The preflight operation is performed by a job with id 0, and is not
+	// counted in the run's results.
+	Preflight   bool
+	Unreachable func(error) bool
+
+	// SetupTimeout, if non-zero, is how long each worker's job has to start
+	// generating load. A job which takes longer is counted as a setup
+	// failure, and stops holding up the run; if it later calls Generator.Do,
+	// it is returned ErrSetupTimeout immediately.
+	SetupTimeout time.Duration
+
+	// ShutdownTimeout, if non-zero, is how long Run waits after the end of
+	// Duration for workers to return. Once it expires, Run returns without
+	// the workers which are still running and counts them as StuckWorkers.
+	// The goroutines of stuck workers are leaked, so this should only be used
+	// to bound runs against operations which may block indefinitely.
+	ShutdownTimeout time.Duration
+
+	// MinOps, if non-zero, is the number of operations each worker records
+	// at least, for runs whose Duration is so short (or whose target is so
+	// slow) that some workers might otherwise record none. A worker which
+	// hasn't recorded that many by the end of Duration carries on at its
+	// rate until it has, so the run can take longer than Duration, in which
+	// case Result.Elapsed is the time it actually took. This applies to
+	// Generator.Do and its variants, including DoEvery, but not to DoAsync
+	// or Record.
+	MinOps int
+
+	// Cancelled, if non-nil, reports whether an error returned by an
+	// operation once the context passed to RunContext is done is due to the
+	// run's cancellation, in which case the operation isn't counted. It is
+	// for jobs whose operations wrap cancellation in errors of their own. If
+	// Cancelled is nil, only errors which are (or wrap) the context's error
+	// are.
+	Cancelled func(error) bool
+
+	// Clock, if non-nil, is the source of time for runs, for tests which
+	// simulate the passage of time and the latencies of operations. By
+	// default, runs use the system clock. SampleResources always measures
+	// CPU usage over system time.
+	Clock Clock
+
+	// Debug, if true, checks that operations are recorded consistently,
+	// panicking if one records more than one outcome, if a success is
+	// counted without its latency being recorded, or if an operation is
+	// recorded after its worker's job has returned. It is meant for testing
+	// jobs and Generator methods, and slows down recording.
+	Debug bool
 }
 
 // Run runs the given job at the given concurrency level, at the given rate,
 // returning a set of results with aggregated latency and throughput
-// measurements.
-func (b Bench) Run(concurrency, rate int, job Job) Result {
-	return b.Runf(concurrency, float64(rate), job)
+// measurements. Any options override the bench's configuration for this run
+// only.
+func (b Bench) Run(concurrency, rate int, job Job, opts ...Option) Result {
+	return b.Runf(concurrency, float64(rate), job, opts...)
 }
 
 // Runf runs the given job at the given concurrency level, at the given rate,
 // returning a set of results with aggregated latency and throughput
 // measurements.
-func (b Bench) Runf(concurrency int, rate float64, job Job) Result {
-	var started, finished sync.WaitGroup
+//
+// If concurrency is zero, a single worker performs operations at the given
+// rate. At low rates this worker is idle for most of the run, which allows
+// testing load of less than one fully-busy worker. The returned Result has a
+// Concurrency of zero in this case. As with Run, any options override the
+// bench's configuration for this run only.
+func (b Bench) Runf(concurrency int, rate float64, job Job, opts ...Option) Result {
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b.run(context.Background(), concurrency, rate, -1, job)
+}
+
+// RunContext runs the given job like Run, except that the run ends early if
+// the given context is cancelled or its deadline expires. Workers stop
+// starting operations as soon as that happens, and Run waits for the
+// operations in progress to finish, or for the bench's ShutdownTimeout from
+// the cancellation if it has one; jobs which pass Generator.Context to their
+// operations let those be abandoned as well. Operations which fail with the
+// context's error once it's done are not counted (see Bench.Cancelled), since
+// the run cancelled them rather than the target failing them. The result of
+// an early end has a StopReason of StopCancelled or StopDeadline, and its
+// Elapsed is the time measured before the end.
+func (b Bench) RunContext(ctx context.Context, concurrency, rate int, job Job, opts ...Option) Result {
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b.run(ctx, concurrency, float64(rate), -1, job)
+}
+
+// RunWorker runs only the worker with the given id of a run at the given
+// concurrency level and rate, with the same schedule it would have had in that
+// run. If the bench has a Seed, the worker's random source is also the same,
+// so with a deterministic job, this replays the sequence of operations the
+// worker performed in that run in isolation.
+func (b Bench) RunWorker(concurrency int, rate float64, id int, job Job) Result {
+	return b.run(context.Background(), concurrency, rate, id, job)
+}
+
+// run runs the given job until the run is over or the context is done, either
+// on all of the workers or, if only is not negative, on the worker with that
+// id.
+func (b Bench) run(ctx context.Context, concurrency int, rate float64, only int, job Job) Result {
+	if job == nil {
+		panic("buster: job function must not be nil")
+	}
+
+	maxLatency, _ := b.maxLatency()
+	if b.Preflight {
+		if err := b.preflight(job); err != nil {
+			now := b.clock().Now()
+			result := Result{
+				Concurrency: concurrency,
+				Latency:     hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+				StopReason:  StopUnreachable,
+				Start:       now,
+				End:         now,
+			}
+			result.addSetupErrors(err, 1, map[string]int{err.Error(): 1})
+			return result
+		}
+	}
+
+	var started sync.WaitGroup
 	started.Add(1)
-	finished.Add(concurrency)
 
 	result := Result{
 		Concurrency: concurrency,
-		Latency:     hdrhistogram.New(us(b.MinLatency), us(b.MaxLatency), 5),
+		Latency:     hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+	}
+	counts := new(counters)
+
+	workers := concurrency
+	if workers == 0 {
+		workers = 1
 	}
-	timings := make(chan *hdrhistogram.Histogram, concurrency)
-	errors := make(chan error, concurrency)
 
-	workerRate := float64(concurrency) / rate
+	// probes run at the same rate as each of the other workers
+	workerRate := float64(workers) / rate
 	period := time.Duration((workerRate)*1000000) * time.Microsecond
+	workers += b.Probes
 
-	for i := 0; i < concurrency; i++ {
-		go func(id int) {
-			defer finished.Done()
+	var ids []int
+	if only >= 0 {
+		ids = []int{only}
+	} else {
+		for id := 0; id < workers; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	shortage := b.fdShortage(len(ids))
+	if shortage != "" {
+		log.Printf("buster: %s", shortage)
+	}
+
+	done := make(chan *Generator, len(ids))
+
+	clock := b.clock()
+	tags := newTagRegistry(b.TagLimit)
 
-			gen := &Generator{
-				hist:     hdrhistogram.New(us(b.MinLatency), us(b.MaxLatency), 5),
-				success:  &result.Success,
-				failure:  &result.Failure,
-				period:   period,
-				duration: b.Duration,
-				warmup:   b.Warmup,
+	var errs *errorSampler
+	if b.RetainErrors > 0 {
+		errs = newErrorSampler(b.RetainErrors, b.seed(workers))
+	}
+	var partitionErrs []*errorSampler
+	if b.RetainErrors > 0 && b.Partitions > 0 {
+		partitionErrs = make([]*errorSampler, b.Partitions)
+		for i := range partitionErrs {
+			partitionErrs[i] = newErrorSampler(b.RetainErrors, b.seed(workers+1+i))
+		}
+	}
+
+	var barrier *sync.WaitGroup
+	if b.SyncStart {
+		barrier = new(sync.WaitGroup)
+		barrier.Add(len(ids))
+	}
+
+	for _, i := range ids {
+		go func(id int) {
+			gen := b.generator(id, counts, errs, clock)
+			gen.tags = tags
+			gen.sample = b.SampleRate
+			gen.warmupOps = b.WarmupOps
+			gen.minOps = b.MinOps
+			gen.arrivals = b.Arrivals
+			gen.stagger = b.Stagger
+			gen.barrier = barrier
+			gen.period = period
+			gen.duration = b.Duration
+			gen.warmup = b.Warmup
+			gen.probe = id >= workers-b.Probes
+			gen.partition = b.partition(id)
+			gen.ctx = ctx
+			gen.cancelledErr = b.Cancelled
+			if partitionErrs != nil {
+				gen.partitionErrs = partitionErrs[gen.partition]
 			}
 
 			started.Wait()
-			errors <- job(id, gen)
-			timings <- gen.hist
+			gen.start = clock.Now()
+			if b.LockOSThread {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+
+			if b.SetupTimeout > 0 {
+				timer := clock.AfterFunc(b.SetupTimeout, func() {
+					if atomic.CompareAndSwapInt32(&gen.state, inSetup, timedOut) {
+						if barrier != nil {
+							gen.arrive.Do(barrier.Done)
+						}
+
+						// report the worker now, since its job may never return
+						done <- &Generator{
+							hist: hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+							err:  fmt.Errorf("%w after %v", ErrSetupTimeout, b.SetupTimeout),
+						}
+					}
+				})
+				defer timer.Stop()
+			}
+
+			if b.OnWorkerStart != nil {
+				b.OnWorkerStart(id)
+			}
+			gen.err = job(id, gen)
+			atomic.StoreInt32(&gen.ended, 1)
+			if b.OnWorkerStop != nil {
+				b.OnWorkerStop(id, gen.err)
+			}
+			if barrier != nil {
+				// don't hold up the other workers if this one never called Do
+				gen.arrive.Do(barrier.Done)
+			}
+
+			if atomic.CompareAndSwapInt32(&gen.state, inSetup, returned) ||
+				atomic.LoadInt32(&gen.state) == running {
+				done <- gen
+			}
 		}(i)
 	}
 
+	var resources *resourceSampler
+	if b.SampleResources {
+		resources = sampleResources()
+	}
+
 	started.Done()
-	finished.Wait()
-	result.Elapsed = b.Duration
+	result.Start = clock.Now().Add(b.Warmup)
+
+	var overflows, probeOverflows []overflow
+	var partitionOverflows [][]overflow
+	if b.Partitions > 0 {
+		result.Partitions = make([]Result, b.Partitions)
+		partitionOverflows = make([][]overflow, b.Partitions)
+	}
+	var busy, delay time.Duration
+	var delays int64
+	var timeout <-chan time.Time
+	if b.ShutdownTimeout > 0 {
+		timeout = after(clock, b.Warmup+b.Duration+b.ShutdownTimeout)
+	}
+
+	cancelled := ctx.Done()
+
+collect:
+	for i := 0; i < len(ids); {
+		select {
+		case gen := <-done:
+			i++
+			if b.Partitions > 0 {
+				p := &result.Partitions[gen.partition]
+				p.Success += atomic.LoadUint64(&gen.successes)
+				p.Failure += atomic.LoadUint64(&gen.failures)
+				p.AppFailures += atomic.LoadUint64(&gen.appFailures)
+				p.TransportFailures += atomic.LoadUint64(&gen.netFailures)
+				p.Latency = mergeInto(p.Latency, gen.hist)
+				partitionOverflows[gen.partition] = append(partitionOverflows[gen.partition], gen.overflow...)
+			}
+			if gen.probe {
+				result.ProbeLatency = mergeInto(result.ProbeLatency, gen.hist)
+				probeOverflows = append(probeOverflows, gen.overflow...)
+			} else {
+				result.Latency.Merge(gen.hist)
+				overflows = append(overflows, gen.overflow...)
+			}
+			busy += gen.busy
+			delay += gen.delay
+			delays += gen.delays
+			result.addSplits(gen.splits)
+			result.addTagged(gen.tagged)
+			result.ConnectLatency = mergeInto(result.ConnectLatency, gen.connHist)
+			result.StreamLatency = mergeInto(result.StreamLatency, gen.streamHist)
+			result.ServerLatency = mergeInto(result.ServerLatency, gen.serverHist)
+			result.WeightedLatency = mergeInto(result.WeightedLatency, gen.weightedHist)
+			result.ItemLatency = mergeInto(result.ItemLatency, gen.itemHist)
+			result.addStatuses(gen.statuses)
+			result.addMetric(gen.metric, gen.reduce)
+			if gen.err != nil {
+				if gen.state == running {
+					result.Errors = append(result.Errors, gen.err)
+					if b.Partitions > 0 {
+						p := &result.Partitions[gen.partition]
+						p.Errors = append(p.Errors, gen.err)
+					}
+				} else {
+					result.addSetupErrors(gen.err, 1, map[string]int{gen.err.Error(): 1})
+				}
+			}
+		case <-cancelled:
+			// the workers stop promptly, but only stuck ones are waited for
+			// beyond the ShutdownTimeout
+			cancelled = nil
+			if b.ShutdownTimeout > 0 {
+				timeout = after(clock, b.ShutdownTimeout)
+			}
+		case <-timeout:
+			result.StuckWorkers = len(ids) - i
+			if ctx.Err() == nil {
+				result.StopReason = StopShutdownTimeout
+			}
+			break collect
+		}
+	}
 
-	close(timings)
-	for v := range timings {
-		result.Latency.Merge(v)
+	sortErrors(result.Errors)
+	result.Latency = widen(result.Latency, overflows)
+	if result.ProbeLatency != nil {
+		result.ProbeLatency = widen(result.ProbeLatency, probeOverflows)
+	}
+	result.End = clock.Now()
+	result.Elapsed = b.Duration
+	if took := result.End.Sub(result.Start); b.MinOps > 0 && took > result.Elapsed {
+		result.Elapsed = took
 	}
+	if took := result.End.Sub(result.Start); ctx.Err() != nil && took < b.Duration &&
+		result.StopReason == StopCompleted {
+		// the run was cut short, rather than done by the time it was cancelled
+		result.StopReason = StopCancelled
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			result.StopReason = StopDeadline
+		}
 
-	close(errors)
-	for e := range errors {
-		if e != nil {
-			result.Errors = append(result.Errors, e)
+		result.Elapsed = 0
+		if took > 0 {
+			result.Elapsed = took
+		}
+	}
+	result.EffectiveConcurrency = effective(busy, result.Elapsed)
+	for _, r := range result.Tagged {
+		r.Elapsed = result.Elapsed
+	}
+	for i := range result.Partitions {
+		p := &result.Partitions[i]
+		p.Concurrency = concurrency
+		p.Elapsed = result.Elapsed
+		if p.Latency == nil {
+			p.Latency = hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5)
+		}
+		p.Latency = widen(p.Latency, partitionOverflows[i])
+		sortErrors(p.Errors)
+		if partitionErrs != nil {
+			partitionErrs[i].load(p)
 		}
 	}
+	if delays > 0 {
+		result.SchedulingDelay = delay / time.Duration(delays)
+		result.GeneratorSaturated = result.SchedulingDelay > period/10
+	}
+	counts.load(&result)
+	if shortage != "" {
+		result.limitedBy(shortage)
+	}
+	if n := atomic.LoadUint64(&counts.fdExhausted); n > 0 {
+		result.limitedBy(fmt.Sprintf("%d operations failed for lack of file descriptors", n))
+	}
+	if resources != nil {
+		resources.finish(&result)
+	}
+	if errs != nil {
+		errs.load(&result)
+	}
+	result.TargetRate = rate * float64(len(ids)) / float64(workers-b.Probes)
+	if result.Elapsed > 0 {
+		result.AchievedRate = float64(result.Success+result.Failure) / result.Elapsed.Seconds()
+	}
 
 	return result
 }
 
+// generator returns a generator for the worker with the given id, configured
+// with the bench's latency bounds and callbacks, which records its operations
+// in the given run-wide counts and errors.
+func (b Bench) generator(id int, counts *counters, errs *errorSampler, clock Clock) *Generator {
+	maxLatency, widenLatency := b.maxLatency()
+	return &Generator{
+		id:            id,
+		hist:          hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+		counts:        counts,
+		errs:          errs,
+		widen:         widenLatency,
+		autoMax:       b.MaxLatency == 0,
+		onOp:          b.OnOperation,
+		rand:          rand.New(rand.NewSource(b.seed(id))),
+		successStatus: b.SuccessStatus,
+		min:           us(b.MinLatency),
+		max:           us(maxLatency),
+		debug:         b.Debug,
+		clock:         clock,
+	}
+}
+
+// autoMaxLatency is the initial bound of the latency histogram if the bench
+// has no MaxLatency.
+const autoMaxLatency = 1 * time.Second
+
+// maxLatency returns the latency histogram's bound, and whether latencies over
+// it should be widened rather than discarded.
+func (b Bench) maxLatency() (time.Duration, bool) {
+	if b.MaxLatency == 0 {
+		return autoMaxLatency, true
+	}
+	return b.MaxLatency, b.WidenLatency
+}
+
+// partition returns the partition of the worker with the given id.
+func (b Bench) partition(id int) int {
+	if b.Partitions <= 0 {
+		return 0
+	}
+	return id % b.Partitions
+}
+
+// seed returns the seed of the random source of the worker with the given id.
+func (b Bench) seed(id int) int64 {
+	if b.Seed == 0 {
+		return time.Now().UnixNano() + int64(id)
+	}
+	return b.Seed + int64(id)
+}
+
+func effective(busy, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(busy) / float64(elapsed)
+}
+
 func us(d time.Duration) int64 {
 	return d.Nanoseconds() / 1000
 }
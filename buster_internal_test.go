@@ -0,0 +1,121 @@
+package buster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// fixedClock is a clock which is stuck at a single time.
+type fixedClock struct {
+	systemClock
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+func TestGeneratorClockAnomaly(t *testing.T) {
+	start := time.Now()
+	gen := &Generator{
+		hist:   hdrhistogram.New(1, 1000000, 5),
+		counts: new(counters),
+		min:    1000,
+		max:    1000000,
+		clock:  fixedClock{t: start},
+	}
+
+	gen.op(func() error {
+		return nil
+	}, func(start, end time.Time) {
+		gen.succeed(us(end.Sub(start)), 0)
+	}, start, start.Add(-1*time.Second))
+
+	var r Result
+	gen.counts.load(&r)
+
+	if v, want := r.ClockAnomalies, uint64(1); v != want {
+		t.Errorf("ClockAnomalies was %d, but expected %d", v, want)
+	}
+
+	if v, want := gen.hist.Min(), int64(1000); v != want {
+		t.Errorf("Recorded latency was %dµs, but expected %dµs", v, want)
+	}
+}
+
+func TestGeneratorDebugDoubleRecord(t *testing.T) {
+	gen := &Generator{
+		hist:   hdrhistogram.New(1, 1000000, 5),
+		counts: new(counters),
+		min:    1,
+		max:    1000000,
+		debug:  true,
+	}
+	ok := gen.ok(0)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Recording an operation twice didn't panic")
+		}
+	}()
+
+	start := time.Now()
+	gen.op(func() error {
+		gen.succeed(1000, 0)
+		return nil
+	}, ok, start, start.Add(-1*time.Second))
+}
+
+func TestGeneratorDebugRecordAfterReturn(t *testing.T) {
+	gen := &Generator{
+		hist:   hdrhistogram.New(1, 1000000, 5),
+		counts: new(counters),
+		min:    1,
+		max:    1000000,
+		debug:  true,
+		ended:  1,
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Recording an operation after the job returned didn't panic")
+		}
+	}()
+
+	gen.fail(errors.New("late"))
+}
+
+// benchmarkOp measures the overhead of timing and recording an operation which
+// does nothing, at the given sample rate.
+func benchmarkOp(b *testing.B, sample int) {
+	gen := &Generator{
+		hist:   hdrhistogram.New(1, 1000000, 5),
+		counts: new(counters),
+		sample: sample,
+		min:    1,
+		max:    1000000,
+		period: time.Millisecond,
+	}
+	f := func() error {
+		return nil
+	}
+	ok := gen.ok(gen.interval())
+	warmed := time.Now().Add(-1 * time.Second)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gen.op(f, ok, time.Now(), warmed)
+	}
+}
+
+func BenchmarkGeneratorOp(b *testing.B) {
+	benchmarkOp(b, 1)
+}
+
+func BenchmarkGeneratorOpSampled(b *testing.B) {
+	benchmarkOp(b, 100)
+}
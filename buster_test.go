@@ -1,15 +1,28 @@
 package buster_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/codahale/buster"
+	"github.com/codahale/buster/bustertest"
+	"github.com/codahale/hdrhistogram"
 )
 
 func Example() {
@@ -90,7 +103,2813 @@ func TestBenchRunErrors(t *testing.T) {
 		return errors.New("woo hoo")
 	})
 
+	if v, want := r.SetupFailures, 10; v != want {
+		t.Fatalf("Setup failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.SetupErrorCounts["woo hoo"], 10; v != want {
+		t.Fatalf("Setup error count was %d, but expected %d", v, want)
+	}
+
+	if r.SetupError == nil {
+		t.Fatalf("Setup error was nil")
+	}
+
+	if v, want := len(r.Errors), 0; v != want {
+		t.Fatalf("Error count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunJobErrors(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(10, 100, func(id int, gen *buster.Generator) error {
+		if err := gen.Do(func() error {
+			return nil
+		}); err != nil {
+			return err
+		}
+		return errors.New("woo hoo")
+	})
+
 	if v, want := len(r.Errors), 10; v != want {
 		t.Fatalf("Error count was %d, but expected %d", v, want)
 	}
+
+	if v, want := r.SetupFailures, 0; v != want {
+		t.Fatalf("Setup failure count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunJobErrorsSorted(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(5, 100, func(id int, gen *buster.Generator) error {
+		if err := gen.Do(func() error {
+			return nil
+		}); err != nil {
+			return err
+		}
+		return fmt.Errorf("worker %d", 4-id)
+	})
+
+	for i, err := range r.Errors {
+		if v, want := err.Error(), fmt.Sprintf("worker %d", i); v != want {
+			t.Errorf("Error %d was %q, but expected %q", i, v, want)
+		}
+	}
+}
+
+func TestSortErrorCounts(t *testing.T) {
+	sorted := buster.SortErrorCounts(map[string]int{"b": 2, "c": 5, "a": 2})
+	want := []buster.ErrorCount{{"c", 5}, {"a", 2}, {"b", 2}}
+	if fmt.Sprint(sorted) != fmt.Sprint(want) {
+		t.Errorf("Sorted counts were %v, but expected %v", sorted, want)
+	}
+}
+
+func TestBenchRunAppFailures(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   1 * time.Second,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(10, 1000, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if id%2 == 0 {
+				return buster.AppError(errors.New("woo hoo"))
+			}
+			return errors.New("woo hoo")
+		})
+	})
+
+	if r.AppFailures == 0 {
+		t.Errorf("AppFailures was 0, but expected more")
+	}
+
+	if r.TransportFailures == 0 {
+		t.Errorf("TransportFailures was 0, but expected more")
+	}
+
+	if v, want := r.Failure, r.AppFailures+r.TransportFailures; v != want {
+		t.Errorf("Failure was %d, but expected %d", v, want)
+	}
+}
+
+type sliceTrace []buster.TraceEvent
+
+func (t *sliceTrace) Next() (buster.TraceEvent, error) {
+	if len(*t) == 0 {
+		return buster.TraceEvent{}, io.EOF
+	}
+	e := (*t)[0]
+	*t = (*t)[1:]
+	return e, nil
+}
+
+func TestBenchReplay(t *testing.T) {
+	bench := buster.Bench{
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	trace := make(sliceTrace, 100)
+	for i := range trace {
+		trace[i] = buster.TraceEvent{Offset: time.Duration(i) * time.Millisecond, Op: i}
+	}
+
+	r := bench.Replay(10, 2, &trace, func(id int, e buster.TraceEvent) error {
+		if e.Op.(int)%10 == 0 {
+			return errors.New("woo hoo")
+		}
+		return nil
+	})
+
+	if v, want := r.Success, uint64(90); v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Failure, uint64(10); v != want {
+		t.Errorf("Failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.StopReason, buster.StopCompleted; v != want {
+		t.Errorf("StopReason was %v, but expected %v", v, want)
+	}
+
+	if r.Elapsed < 49*time.Millisecond {
+		t.Errorf("Elapsed was %v, but expected at least 49ms", r.Elapsed)
+	}
+}
+
+func TestBenchReplayOptions(t *testing.T) {
+	var ops int64
+	bench := buster.Bench{
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Second,
+		RetainErrors: 5,
+		Debug:        true,
+		OnOperation: func(buster.OpResult) {
+			atomic.AddInt64(&ops, 1)
+		},
+	}
+
+	trace := make(sliceTrace, 20)
+	for i := range trace {
+		trace[i] = buster.TraceEvent{Offset: time.Duration(i) * time.Millisecond, Op: i}
+	}
+
+	r := bench.Replay(2, 1, &trace, func(id int, e buster.TraceEvent) error {
+		if e.Op.(int)%2 == 0 {
+			return errors.New("woo hoo")
+		}
+		return nil
+	})
+
+	if v, want := atomic.LoadInt64(&ops), int64(20); v != want {
+		t.Errorf("OnOperation was called %d times, but expected %d", v, want)
+	}
+
+	if v, want := r.ErrorCounts["woo hoo"], 10; v != want {
+		t.Errorf("Error count was %d, but expected %d", v, want)
+	}
+
+	if v, want := len(r.ErrorSamples), 5; v != want {
+		t.Errorf("There were %d error samples, but expected %d", v, want)
+	}
+}
+
+func TestBenchReplayShutdownTimeout(t *testing.T) {
+	bench := buster.Bench{
+		MinLatency:      1 * time.Microsecond,
+		MaxLatency:      1 * time.Second,
+		ShutdownTimeout: 50 * time.Millisecond,
+	}
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	trace := make(sliceTrace, 10)
+	for i := range trace {
+		trace[i] = buster.TraceEvent{Op: i}
+	}
+
+	begin := time.Now()
+	r := bench.Replay(1, 1, &trace, func(id int, e buster.TraceEvent) error {
+		<-stuck
+		return nil
+	})
+
+	if took := time.Since(begin); took > 1*time.Second {
+		t.Errorf("Replay took %v, but expected it to give up on the stuck worker", took)
+	}
+
+	if v, want := r.StuckWorkers, 1; v != want {
+		t.Errorf("StuckWorkers was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.StopReason, buster.StopShutdownTimeout; v != want {
+		t.Errorf("StopReason was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchReplayZeroConcurrency(t *testing.T) {
+	bench := buster.Bench{
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	trace := make(sliceTrace, 10)
+	for i := range trace {
+		trace[i] = buster.TraceEvent{Offset: time.Duration(i) * time.Millisecond, Op: i}
+	}
+
+	r := bench.Replay(0, 1, &trace, func(id int, e buster.TraceEvent) error {
+		return nil
+	})
+
+	if v, want := r.Success, uint64(10); v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Concurrency, 0; v != want {
+		t.Errorf("Concurrency was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchReplayInvalid(t *testing.T) {
+	for _, test := range []struct {
+		concurrency int
+		speed       float64
+		want        string
+	}{
+		{-1, 1, "buster: replay concurrency must not be negative"},
+		{1, 0, "buster: replay speed must be positive"},
+		{1, -2, "buster: replay speed must be positive"},
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v != test.want {
+					t.Errorf("Panic for concurrency %d and speed %v was %v, but expected %q", test.concurrency, test.speed, v, test.want)
+				}
+			}()
+
+			trace := sliceTrace{{Op: 0}}
+			buster.Bench{}.Replay(test.concurrency, test.speed, &trace, func(int, buster.TraceEvent) error {
+				return nil
+			})
+		}()
+	}
+}
+
+func TestResultAdd(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	job := func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	}
+
+	a := bench.Run(2, 100, job)
+	b := bench.Run(2, 100, job)
+
+	var total buster.Result
+	total.Add(a)
+	total.Add(b)
+
+	if v, want := total.Success, a.Success+b.Success; v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := total.Latency.TotalCount(), a.Latency.TotalCount()+b.Latency.TotalCount(); v != want {
+		t.Errorf("Latency count was %d, but expected %d", v, want)
+	}
+}
+
+func TestResultAddWidened(t *testing.T) {
+	narrow := hdrhistogram.New(1, 1000000, 5)
+	wide := hdrhistogram.New(1, 3000000, 5)
+	for i := int64(1); i <= 100; i++ {
+		if err := narrow.RecordValue(i * 1000); err != nil {
+			t.Fatal(err)
+		}
+		if err := wide.RecordValue(i * 29000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, results := range [][]buster.Result{
+		{{Latency: narrow}, {Latency: wide}},
+		{{Latency: wide}, {Latency: narrow}},
+	} {
+		var total buster.Result
+		for _, r := range results {
+			total.Add(r)
+		}
+
+		if v, want := total.Latency.TotalCount(), int64(200); v != want {
+			t.Errorf("Latency count was %d, but expected %d", v, want)
+		}
+
+		if v, want := total.Latency.Max(), wide.Max(); v != want {
+			t.Errorf("Max latency was %dµs, but expected %dµs", v, want)
+		}
+	}
+
+	if v, want := narrow.TotalCount(), int64(100); v != want {
+		t.Errorf("Merging changed the narrow histogram's count to %d", v)
+	}
+}
+
+func TestBenchRunWidenSideLatencies(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+	}
+
+	r := bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoServerTime(func() (time.Duration, error) {
+			return 3 * time.Second, nil
+		})
+	})
+
+	if r.Success == 0 {
+		t.Fatal("Success count was 0, but expected more")
+	}
+
+	if v, want := r.ServerLatency.TotalCount(), int64(r.Success); v != want {
+		t.Errorf("Server latency count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.ServerLatency.Max(), int64(2990000); v < want {
+		t.Errorf("Max server latency was %dµs, but expected at least %dµs", v, want)
+	}
+}
+
+func TestSummarizeWidenedSaturated(t *testing.T) {
+	narrow := hdrhistogram.New(1, 1000000, 5)
+	wide := hdrhistogram.New(1, 3000000, 5)
+	for i := int64(1); i <= 100; i++ {
+		if err := narrow.RecordValue(i * 1000); err != nil {
+			t.Fatal(err)
+		}
+		if err := wide.RecordValue(i * 29000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := buster.Summarize([]buster.Result{
+		{Latency: narrow, Elapsed: 1 * time.Second, SchedulingDelay: 1 * time.Millisecond},
+		{Latency: wide, Elapsed: 1 * time.Second, SchedulingDelay: 5 * time.Millisecond, GeneratorSaturated: true},
+		{Latency: narrow, Elapsed: 1 * time.Second},
+	})
+
+	if v, want := r.Latency.TotalCount(), int64(300); v != want {
+		t.Errorf("Latency count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Latency.Max(), wide.Max(); v != want {
+		t.Errorf("Max latency was %dµs, but expected %dµs", v, want)
+	}
+
+	if !r.GeneratorSaturated {
+		t.Error("Summary wasn't saturated, but expected it to be")
+	}
+
+	if v, want := r.SchedulingDelay, 5*time.Millisecond; v != want {
+		t.Errorf("Scheduling delay was %v, but expected %v", v, want)
+	}
+}
+
+func TestResultAddErrors(t *testing.T) {
+	a := buster.Result{Errors: []error{errors.New("b"), errors.New("d")}}
+	b := buster.Result{Errors: []error{errors.New("a"), errors.New("c")}}
+
+	var total buster.Result
+	total.Add(a)
+	total.Add(b)
+
+	var msgs []string
+	for _, err := range total.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	if v, want := strings.Join(msgs, ","), "a,b,c,d"; v != want {
+		t.Errorf("Errors were %s, but expected %s", v, want)
+	}
+}
+
+func TestBenchRunShutdownTimeout(t *testing.T) {
+	bench := buster.Bench{
+		Duration:        100 * time.Millisecond,
+		MinLatency:      1 * time.Millisecond,
+		MaxLatency:      1 * time.Second,
+		ShutdownTimeout: 100 * time.Millisecond,
+	}
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	r := bench.Run(4, 100, func(id int, gen *buster.Generator) error {
+		if id == 0 {
+			<-stuck
+			return nil
+		}
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.StuckWorkers, 1; v != want {
+		t.Errorf("StuckWorkers was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.StopReason, buster.StopShutdownTimeout; v != want {
+		t.Errorf("StopReason was %v, but expected %v", v, want)
+	}
+}
+
+func TestGeneratorCount(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	var counts [2]int64
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		err := gen.Do(func() error {
+			return nil
+		})
+		counts[id] = gen.Count()
+		return err
+	})
+
+	if v, want := uint64(counts[0]+counts[1]), r.Success; v != want {
+		t.Errorf("Count total was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunNilJob(t *testing.T) {
+	defer func() {
+		if v, want := recover(), "buster: job function must not be nil"; v != want {
+			t.Errorf("Panic was %v, but expected %q", v, want)
+		}
+	}()
+
+	buster.Bench{}.Run(1, 1, nil)
+}
+
+func TestBenchRunZeroConcurrency(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Runf(0, 50, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.Concurrency, 0; v != want {
+		t.Errorf("Concurrency was %d, but expected %d", v, want)
+	}
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+}
+
+func TestBenchRunWidenLatency(t *testing.T) {
+	bench := buster.Bench{
+		Duration:     100 * time.Millisecond,
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Millisecond,
+		WidenLatency: true,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if v, want := r.Latency.Max(), int64(5000); v < want {
+		t.Errorf("Max latency was %dµs, but expected at least %dµs", v, want)
+	}
+
+	if v, want := r.Latency.TotalCount(), int64(r.Success); v < want {
+		t.Errorf("Latency count was %d, but expected at least %d", v, want)
+	}
+
+	if v, want := r.Overflow, r.Success; v != want {
+		t.Errorf("Overflow count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunAutoMaxLatency(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+	}
+
+	r := bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(1100 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if v, want := r.Latency.Max(), int64(1100000); v < want {
+		t.Errorf("Max latency was %dµs, but expected at least %dµs", v, want)
+	}
+
+	if v, want := r.Overflow, uint64(0); v != want {
+		t.Errorf("Overflow count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunUnderflow(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 100 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.Underflow, r.Success; v != want || v == 0 {
+		t.Errorf("Underflow count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Overflow, uint64(0); v != want {
+		t.Errorf("Overflow count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunEffectiveConcurrency(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   200 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// each worker is busy for 5ms of every 20ms
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if v := r.EffectiveConcurrency; v < 0.25 || v > 1 {
+		t.Errorf("EffectiveConcurrency was %f, but expected about 0.5", v)
+	}
+}
+
+func TestWriteLineProtocol(t *testing.T) {
+	h := hdrhistogram.New(1, 1000000, 5)
+	for i := int64(1); i <= 100; i++ {
+		if err := h.RecordValue(i * 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := buster.Result{
+		Concurrency: 10,
+		Elapsed:     10 * time.Second,
+		End:         time.Unix(1, 0),
+		Success:     100,
+		Failure:     5,
+		Latency:     h,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := buster.WriteLineProtocol(buf, []buster.Result{r}, "load test", map[string]string{
+		"target": "a,b",
+		"env":    "ci",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `load\ test,concurrency=10,env=ci,target=a\,b throughput=10,success=100i,failure=5i,p50=50000i,p90=90000i,p99=99000i,p999=100000i,max=100000i 1000000000` + "\n"
+	if v := buf.String(); v != want {
+		t.Errorf("Line was\n%s\nbut expected\n%s", v, want)
+	}
+}
+
+func TestWriteLineProtocolEmpty(t *testing.T) {
+	r := buster.Result{
+		Concurrency: 1,
+		End:         time.Unix(1, 0),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := buster.WriteLineProtocol(buf, []buster.Result{r}, "load", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `load,concurrency=1 throughput=0,success=0i,failure=0i 1000000000` + "\n"
+	if v := buf.String(); v != want {
+		t.Errorf("Line was\n%s\nbut expected\n%s", v, want)
+	}
+}
+
+func TestGeneratorSplit(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(1 * time.Millisecond)
+			gen.Split("auth")
+			time.Sleep(2 * time.Millisecond)
+			gen.Split("query")
+			return nil
+		})
+	})
+
+	for name, min := range map[string]int64{"auth": 1000, "query": 2000} {
+		h, ok := r.Splits[name]
+		if !ok {
+			t.Fatalf("No split recorded for %s", name)
+		}
+
+		if v, want := h.TotalCount(), int64(r.Success); v != want {
+			t.Errorf("%s count was %d, but expected %d", name, v, want)
+		}
+
+		if v := h.Min(); v < min {
+			t.Errorf("%s min was %dµs, but expected at least %dµs", name, v, min)
+		}
+	}
+}
+
+func TestResultReport(t *testing.T) {
+	h := hdrhistogram.New(1, 10000000, 5)
+	for _, v := range []int64{500, 1500000} {
+		if err := h.RecordValue(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := buster.Result{
+		Elapsed: 1 * time.Second,
+		Success: 2,
+		Latency: h,
+	}
+
+	want := "2 successes, 0 failures, 0 errors, 0 setup errors, 2.000000 ops/sec\n" +
+		"p0.000000 = 500.000µs\n" +
+		"p50.000000 = 500.000µs\n" +
+		"p75.000000 = 1.500s\n" +
+		"p100.000000 = 1.500s\n"
+	if v := r.String(); v != want {
+		t.Errorf("String was\n%s\nbut expected\n%s", v, want)
+	}
+
+	want = "2 successes, 0 failures, 0 errors, 0 setup errors, 2.000000 ops/sec\n" +
+		"p0.000000 = 0.5ms\n" +
+		"p50.000000 = 0.5ms\n" +
+		"p75.000000 = 1500.0ms\n" +
+		"p100.000000 = 1500.0ms\n"
+	if v := r.Report(buster.ReportOptions{Unit: time.Millisecond, Precision: 1}); v != want {
+		t.Errorf("Report was\n%s\nbut expected\n%s", v, want)
+	}
+}
+
+func TestResultReportTopErrors(t *testing.T) {
+	h := hdrhistogram.New(1, 10000000, 5)
+	if err := h.RecordValue(500); err != nil {
+		t.Fatal(err)
+	}
+
+	r := buster.Result{
+		Elapsed:     1 * time.Second,
+		Success:     1,
+		Failure:     9,
+		Latency:     h,
+		ErrorCounts: map[string]int{"timeout": 4, "refused": 2, "reset": 1},
+		Errors:      []error{errors.New("timeout"), errors.New("eof")},
+	}
+
+	want := "1 successes, 9 failures, 2 errors, 0 setup errors, 1.000000 ops/sec\n" +
+		"p0.000000 = 500.000µs\n" +
+		"p100.000000 = 500.000µs\n" +
+		"5 × timeout\n" +
+		"2 × refused\n" +
+		"... and 2 more errors\n"
+	if v := r.Report(buster.ReportOptions{Precision: 3, TopErrors: 2}); v != want {
+		t.Errorf("Report was\n%s\nbut expected\n%s", v, want)
+	}
+
+	if v := r.Report(buster.ReportOptions{Precision: 3, TopErrors: -1}); strings.Contains(v, "timeout") {
+		t.Errorf("Report listed errors, but expected none:\n%s", v)
+	}
+}
+
+func TestResultPercentile(t *testing.T) {
+	h := hdrhistogram.New(1, 10000000, 5)
+	for _, v := range []int64{1000, 2000} {
+		if err := h.RecordValue(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := buster.Result{Latency: h}
+
+	if v, want := r.Percentile(50), 1*time.Millisecond; v != want {
+		t.Errorf("p50 was %v, but expected %v", v, want)
+	}
+
+	for q, want := range map[float64]time.Duration{
+		0:   1000 * time.Microsecond,
+		25:  1250 * time.Microsecond,
+		50:  1500 * time.Microsecond,
+		100: 2000 * time.Microsecond,
+	} {
+		if v := r.InterpolatedPercentile(q); v != want {
+			t.Errorf("Interpolated p%v was %v, but expected %v", q, v, want)
+		}
+	}
+}
+
+func TestResultDistribution(t *testing.T) {
+	h := hdrhistogram.New(1, 10000000, 5)
+	for _, v := range []int64{500, 1500, 1600, 3500} {
+		if err := h.RecordValue(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := buster.Result{Latency: h}
+
+	linear := []buster.Bar{
+		{From: 0, To: 1 * time.Millisecond, Count: 1},
+		{From: 1 * time.Millisecond, To: 2 * time.Millisecond, Count: 2},
+		{From: 2 * time.Millisecond, To: 3 * time.Millisecond, Count: 0},
+		{From: 3 * time.Millisecond, To: 4 * time.Millisecond, Count: 1},
+	}
+	if v, want := r.LinearDistribution(1*time.Millisecond), linear; fmt.Sprint(v) != fmt.Sprint(want) {
+		t.Errorf("Linear distribution was %v, but expected %v", v, want)
+	}
+
+	log := []buster.Bar{
+		{From: 0, To: 1 * time.Millisecond, Count: 1},
+		{From: 1 * time.Millisecond, To: 2 * time.Millisecond, Count: 2},
+		{From: 2 * time.Millisecond, To: 4 * time.Millisecond, Count: 1},
+	}
+	if v, want := r.LogDistribution(2, 1*time.Millisecond), log; fmt.Sprint(v) != fmt.Sprint(want) {
+		t.Errorf("Log distribution was %v, but expected %v", v, want)
+	}
+}
+
+func TestResultFingerprint(t *testing.T) {
+	result := func(success uint64, latency int64) buster.Result {
+		h := hdrhistogram.New(1, 10000000, 5)
+		if err := h.RecordValue(latency); err != nil {
+			t.Fatal(err)
+		}
+		return buster.Result{
+			Concurrency: 4,
+			Elapsed:     time.Second,
+			Success:     success,
+			Latency:     h,
+		}
+	}
+
+	base := result(1000, 1000).Fingerprint(0.05)
+	if v := result(1002, 1002).Fingerprint(0.05); v != base {
+		t.Errorf("Near-identical fingerprint was %s, but expected %s", v, base)
+	}
+
+	if v := result(1000, 2000).Fingerprint(0.05); v == base {
+		t.Errorf("Fingerprint of slower result was %s, but expected a change", v)
+	}
+
+	if v := result(500, 1000).Fingerprint(0.05); v == base {
+		t.Errorf("Fingerprint of lower throughput was %s, but expected a change", v)
+	}
+
+	if v := result(1000, 2000).Fingerprint(1); v != result(1000, 1500).Fingerprint(1) {
+		t.Errorf("Fingerprints differed within a tolerance of 100%%")
+	}
+}
+
+func TestResultToMap(t *testing.T) {
+	h := hdrhistogram.New(1, 10000000, 5)
+	for _, v := range []int64{500, 1500000} {
+		if err := h.RecordValue(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := buster.Result{
+		Concurrency: 4,
+		Elapsed:     2 * time.Second,
+		Success:     3,
+		Failure:     1,
+		Latency:     h,
+	}
+
+	m := r.ToMap()
+	for k, want := range map[string]interface{}{
+		"concurrency": 4,
+		"success":     uint64(3),
+		"failure":     uint64(1),
+		"throughput":  1.5,
+		"error_rate":  0.25,
+		"p50":         int64(500),
+		"stop_reason": "completed",
+	} {
+		if v := m[k]; v != want {
+			t.Errorf("%s was %v, but expected %v", k, v, want)
+		}
+	}
+}
+
+func TestBenchRunToRate(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   300 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// each worker can manage at most 100 ops/sec
+	job := func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+	}
+
+	r, ok := bench.RunToRate(300, 16, 99, 0, job)
+	if !ok {
+		t.Fatalf("Rate was unreachable, with an achieved rate of %f", r.AchievedRate)
+	}
+
+	if v := r.Concurrency; v < 4 {
+		t.Errorf("Concurrency was %d, but expected at least 4", v)
+	}
+
+	if r, ok := bench.RunToRate(300, 16, 99, 5*time.Millisecond, job); ok {
+		t.Errorf("Rate was reachable at concurrency %d, but expected the budget to be exceeded", r.Concurrency)
+	}
+}
+
+func TestBenchRunConverged(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := bustertest.NewClock(start)
+	recorded := make(chan struct{})
+	bench := buster.Bench{
+		Warmup:     1 * time.Hour,
+		Duration:   55 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Clock:      clock,
+		OnOperation: func(buster.OpResult) {
+			recorded <- struct{}{}
+		},
+	}
+
+	// the target speeds up from 9ms to 1ms over its first 9 operations, and
+	// each 55ms window has operations at 10ms, 20ms, ..., 50ms
+	n := 0
+	results := make(chan buster.Result)
+	go func() {
+		results <- bench.RunConverged(1, 100, 55*time.Millisecond, 2*time.Second, 0.2, func(id int, gen *buster.Generator) error {
+			return gen.Do(func() error {
+				n++
+				latency := time.Duration(10-n) * time.Millisecond
+				if latency < 1*time.Millisecond {
+					latency = 1 * time.Millisecond
+				}
+				clock.Advance(latency)
+				return nil
+			})
+		})
+	}()
+
+	var r buster.Result
+	for done := false; !done; {
+		select {
+		case r = <-results:
+			done = true
+			continue
+		default:
+		}
+
+		// wait for the next window's ticker and end to be scheduled, then
+		// tick through it
+		if clock.Pending() < 2 {
+			runtime.Gosched()
+			continue
+		}
+
+		window := clock.Now()
+		for tick := 10 * time.Millisecond; tick < 55*time.Millisecond; tick += 10 * time.Millisecond {
+			clock.Advance(window.Add(tick).Sub(clock.Now()))
+			<-recorded
+		}
+		clock.Advance(window.Add(55 * time.Millisecond).Sub(clock.Now()))
+	}
+
+	// the p99 latencies of the windows are 9ms, 4ms, 1ms, and 1ms
+	if v, want := r.Warmup, 4*55*time.Millisecond; v != want {
+		t.Errorf("Warmup was %v, but expected %v", v, want)
+	}
+
+	if v, want := r.Latency.Max(), int64(1000); v != want {
+		t.Errorf("Max latency was %dµs, but expected the slow start to be excluded", v)
+	}
+
+	if v, want := r.Success, uint64(5); v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Elapsed, bench.Duration; v != want {
+		t.Errorf("Elapsed was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchRunConvergedInvalid(t *testing.T) {
+	job := func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	}
+
+	for _, test := range []struct {
+		window, maxWarmup time.Duration
+		want              string
+	}{
+		{0, 1 * time.Second, "buster: converged window must be positive"},
+		{-1 * time.Second, 1 * time.Second, "buster: converged window must be positive"},
+		{10 * time.Millisecond, 0, "buster: converged max warmup must be positive"},
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v != test.want {
+					t.Errorf("Panic for window %v and max warmup %v was %v, but expected %q", test.window, test.maxWarmup, v, test.want)
+				}
+			}()
+
+			buster.Bench{}.RunConverged(1, 1, test.window, test.maxWarmup, 0.1, job)
+		}()
+	}
+}
+
+func TestBenchRunPlan(t *testing.T) {
+	bench := buster.Bench{
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	stages := []buster.Stage{
+		{Concurrency: 1, Rate: 100, Duration: 50 * time.Millisecond},
+		{Concurrency: 4, Rate: 400, Duration: 100 * time.Millisecond},
+	}
+
+	results := bench.RunPlan(stages, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := len(results), len(stages); v != want {
+		t.Fatalf("Plan had %d results, but expected %d", v, want)
+	}
+
+	for i, r := range results {
+		if v, want := r.Concurrency, stages[i].Concurrency; v != want {
+			t.Errorf("Stage %d concurrency was %d, but expected %d", i, v, want)
+		}
+
+		if v, want := r.Elapsed, stages[i].Duration; v != want {
+			t.Errorf("Stage %d elapsed was %v, but expected %v", i, v, want)
+		}
+	}
+
+	if results[1].Success <= results[0].Success {
+		t.Errorf("Stage success counts were %d and %d, but expected the second to be higher",
+			results[0].Success, results[1].Success)
+	}
+}
+
+func TestBenchRunStable(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.RunStable(2, 200, 50, 0.5, 1*time.Second, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(1 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if r.Elapsed < 100*time.Millisecond || r.Elapsed >= 1*time.Second {
+		t.Errorf("Elapsed was %v, but expected to converge after a few windows", r.Elapsed)
+	}
+}
+
+func TestBenchRunStableCounts(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 100 * time.Millisecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.RunStable(2, 200, 50, 0.5, 1*time.Second, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if r.Elapsed < 100*time.Millisecond {
+		t.Fatalf("Elapsed was %v, but expected more than one window", r.Elapsed)
+	}
+
+	if v, want := r.Underflow, r.Success; v != want || v == 0 {
+		t.Errorf("Underflow count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunSyncStart(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		SyncStart:  true,
+	}
+
+	var mu sync.Mutex
+	var first []time.Time
+
+	bench.Run(10, 10, func(id int, gen *buster.Generator) error {
+		if id == 0 {
+			return errors.New("never calls Do")
+		}
+
+		time.Sleep(time.Duration(id) * time.Millisecond)
+
+		once := true
+		return gen.Do(func() error {
+			if once {
+				once = false
+				mu.Lock()
+				first = append(first, time.Now())
+				mu.Unlock()
+			}
+			return nil
+		})
+	})
+
+	if v, want := len(first), 9; v != want {
+		t.Fatalf("%d workers fired, but expected %d", v, want)
+	}
+
+	min, max := first[0], first[0]
+	for _, v := range first {
+		if v.Before(min) {
+			min = v
+		}
+		if v.After(max) {
+			max = v
+		}
+	}
+
+	if spread := max.Sub(min); spread > 5*time.Millisecond {
+		t.Errorf("First operations were spread over %v, but expected them to be simultaneous", spread)
+	}
+}
+
+func TestGeneratorDoConnect(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoConnect(func() error {
+			return nil
+		})
+	})
+
+	if r.Connects == 0 {
+		t.Errorf("Connects was 0, but expected more")
+	}
+
+	if v, want := r.ConnectLatency.TotalCount(), int64(r.Connects); v != want {
+		t.Errorf("ConnectLatency count was %d, but expected %d", v, want)
+	}
+
+	if r.Success != 0 {
+		t.Errorf("Success count was %d, but expected 0", r.Success)
+	}
+}
+
+func TestBenchRunSchedulingDelay(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// operations which overrun their period don't count as scheduling delay
+	r := bench.Run(2, 200, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if r.GeneratorSaturated {
+		t.Errorf("Generator was saturated with a scheduling delay of %v", r.SchedulingDelay)
+	}
+}
+
+func TestGeneratorDoStream(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoStream(func(record func(time.Duration)) error {
+			for i := 0; i < 3; i++ {
+				record(1 * time.Millisecond)
+			}
+			return nil
+		})
+	})
+
+	if v, want := r.StreamLatency.TotalCount(), int64(3*r.Success); v != want {
+		t.Errorf("StreamLatency count was %d, but expected %d", v, want)
+	}
+}
+
+func TestHTTPJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, buster.HTTPJob(func(id int) *http.Request {
+		path := "/"
+		if id == 1 {
+			path = "/fail"
+		}
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		return req
+	}, buster.HTTPTimeout(1*time.Second)))
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+
+	if r.AppFailures == 0 {
+		t.Errorf("AppFailures was 0, but expected more")
+	}
+
+	if r.TransportFailures != 0 {
+		t.Errorf("TransportFailures was %d, but expected 0", r.TransportFailures)
+	}
+
+	if v, want := uint64(r.StatusCounts[503]), r.AppFailures; v != want {
+		t.Errorf("503 count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchCompareKeepAlive(t *testing.T) {
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	keepAlive, noKeepAlive := bench.CompareKeepAlive(1, 100, func(id int) *http.Request {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		return req
+	}, buster.HTTPTimeout(1*time.Second))
+
+	if keepAlive.Success < 2 || noKeepAlive.Success < 2 {
+		t.Fatalf("Success counts were %d and %d, but expected more", keepAlive.Success, noKeepAlive.Success)
+	}
+
+	// one connection for the first run, and one per request for the second
+	v, want := atomic.LoadInt64(&conns), int64(noKeepAlive.Success)+1
+	if v != want {
+		t.Errorf("Runs made %d connections, but expected %d", v, want)
+	}
+}
+
+func TestGeneratorDoServerTime(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoServerTime(func() (time.Duration, error) {
+			time.Sleep(3 * time.Millisecond)
+			return 1 * time.Millisecond, nil
+		})
+	})
+
+	if r.ServerLatency == nil {
+		t.Fatal("ServerLatency was nil")
+	}
+
+	if v, want := r.ServerLatency.TotalCount(), int64(r.Success); v != want {
+		t.Errorf("Server latency count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.ServerLatency.Max(), int64(1000); v != want {
+		t.Errorf("Max server latency was %dµs, but expected %dµs", v, want)
+	}
+
+	if v, want := r.Latency.Min(), int64(3000); v < want {
+		t.Errorf("Min client latency was %dµs, but expected at least %dµs", v, want)
+	}
+}
+
+func TestBenchRunDebug(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		SampleRate: 2,
+		Debug:      true,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoBatch(func(record func(time.Duration)) error {
+			record(100 * time.Microsecond)
+			if id == 1 {
+				return errors.New("batch failed")
+			}
+			return nil
+		})
+	})
+
+	if r.Success == 0 || r.Failure == 0 {
+		t.Errorf("Counts were %d successes and %d failures, but expected both", r.Success, r.Failure)
+	}
+}
+
+func TestGeneratorDoWeightedLatency(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   200 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// one operation in ten is slow and costs a hundred times as much
+	var n int64
+	r := bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoWeightedLatency(func() (int64, error) {
+			if atomic.AddInt64(&n, 1)%10 == 0 {
+				time.Sleep(5 * time.Millisecond)
+				return 100, nil
+			}
+			return 1, nil
+		})
+	})
+
+	if r.WeightedLatency == nil {
+		t.Fatal("No weighted latencies were recorded")
+	}
+
+	if v := r.Latency.ValueAtQuantile(50); v >= 5000 {
+		t.Errorf("Median latency was %dµs, but expected cheap operations to dominate", v)
+	}
+
+	if v := r.WeightedLatency.ValueAtQuantile(50); v < 5000 {
+		t.Errorf("Median weighted latency was %dµs, but expected costly operations to dominate", v)
+	}
+}
+
+func TestGeneratorDoBatch(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	var n int64
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoBatch(func(record func(time.Duration)) error {
+			for i := 0; i < 4; i++ {
+				record(250 * time.Microsecond)
+			}
+			if atomic.AddInt64(&n, 1)%2 == 0 {
+				return errors.New("batch failed")
+			}
+			return nil
+		})
+	})
+
+	if r.Success == 0 || r.Failure == 0 {
+		t.Fatalf("Counts were %d successes and %d failures, but expected both", r.Success, r.Failure)
+	}
+
+	if v, want := r.Items, 4*r.Success; v != want {
+		t.Errorf("Item count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.ItemLatency.TotalCount(), int64(r.Items); v != want {
+		t.Errorf("Item latency count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.ItemLatency.Max(), int64(250); v != want {
+		t.Errorf("Max item latency was %dµs, but expected %dµs", v, want)
+	}
+}
+
+func TestGeneratorDoStatus(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		SuccessStatus: func(status int) bool {
+			return status == 200
+		},
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.DoStatus(func() (int, error) {
+			return 200 + id*229, nil
+		})
+	})
+
+	if v, want := uint64(r.StatusCounts[200]), r.Success; v != want {
+		t.Errorf("200 count was %d, but expected %d", v, want)
+	}
+
+	if v, want := uint64(r.StatusCounts[429]), r.AppFailures; v != want {
+		t.Errorf("429 count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunSampleRate(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		SampleRate: 10,
+	}
+
+	r := bench.Run(2, 1000, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.Latency.TotalCount(), int64(r.Success/10); v > want+2 {
+		t.Errorf("Latency count was %d, but expected about %d", v, want)
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	var results []buster.Result
+	for c := 1; c <= 3; c++ {
+		h := hdrhistogram.New(1, 1000000, 5)
+		for i := int64(1); i <= 100; i++ {
+			if err := h.RecordValue(i * int64(c) * 100); err != nil {
+				t.Fatal(err)
+			}
+		}
+		results = append(results, buster.Result{Concurrency: c, Latency: h})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := buster.WriteSVG(buf, results, []float64{50, 99}); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := buf.String()
+	if !strings.HasPrefix(svg, "<svg") || !strings.HasSuffix(svg, "</svg>\n") {
+		t.Errorf("Output was not an SVG document:\n%s", svg)
+	}
+
+	if v, want := strings.Count(svg, "<polyline"), 2; v != want {
+		t.Errorf("Chart had %d lines, but expected %d", v, want)
+	}
+
+	// results are sorted, and those without latencies skipped
+	shuffled := []buster.Result{results[2], {}, results[0], buster.Summarize(nil), results[1]}
+	buf.Reset()
+	if err := buster.WriteSVG(buf, shuffled, []float64{50, 99}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := buf.String(); v != svg {
+		t.Errorf("Chart of shuffled results was\n%s\nbut expected\n%s", v, svg)
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	result := func(success uint64, latency int64) buster.Result {
+		h := hdrhistogram.New(1, 1000000, 5)
+		if err := h.RecordValue(latency); err != nil {
+			t.Fatal(err)
+		}
+		return buster.Result{Concurrency: 1, Elapsed: 1 * time.Second, Success: success, Latency: h}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	baseline := []buster.Result{result(100, 1000)}
+	current := []buster.Result{result(101, 2000)}
+	if err := buster.WriteComparison(buf, baseline, current); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "concurrency  metric   baseline  current  delta\n" +
+		"1            ops/sec  100.0     101.0    +1.0%\n" +
+		"             p50      1.000ms   2.000ms  +100.0% ▲ regression\n" +
+		"             p99      1.000ms   2.000ms  +100.0% ▲ regression\n" +
+		"             p99.9    1.000ms   2.000ms  +100.0% ▲ regression\n"
+	if v := buf.String(); v != want {
+		t.Errorf("Comparison was\n%s\nbut expected\n%s", v, want)
+	}
+}
+
+func TestByRate(t *testing.T) {
+	result := func(c int, success uint64, latency int64) buster.Result {
+		h := hdrhistogram.New(1, 1000000, 5)
+		if err := h.RecordValue(latency); err != nil {
+			t.Fatal(err)
+		}
+		return buster.Result{Concurrency: c, Elapsed: 1 * time.Second, Success: success, Latency: h}
+	}
+
+	// throughput falls off past saturation
+	points := buster.ByRate([]buster.Result{
+		result(1, 100, 1000),
+		result(2, 200, 1000),
+		result(4, 150, 5000),
+	})
+
+	for i, want := range []buster.RatePoint{
+		{Concurrency: 1, Rate: 100, P50: 1 * time.Millisecond},
+		{Concurrency: 4, Rate: 150, P50: 5 * time.Millisecond},
+		{Concurrency: 2, Rate: 200, P50: 1 * time.Millisecond},
+	} {
+		p := points[i]
+		if p.Concurrency != want.Concurrency || p.Rate != want.Rate || p.P50 != want.P50 {
+			t.Errorf("Point %d was %+v, but expected %+v", i, p, want)
+		}
+	}
+}
+
+func TestWriteBenchmarks(t *testing.T) {
+	h := hdrhistogram.New(1, 1000000, 5)
+	if err := h.RecordValue(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []buster.Result{
+		{Concurrency: 4, Elapsed: 2 * time.Second, Success: 30, Failure: 10, Latency: h},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := buster.WriteBenchmarks(buf, "my api", results); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if v, want := len(lines), 3; v != want {
+		t.Fatalf("Output had %d lines, but expected %d:\n%s", v, want, buf)
+	}
+
+	fields := strings.Split(lines[2], "\t")
+	if v, want := fields[0], fmt.Sprintf("BenchmarkMy_api/concurrency=4-%d", runtime.GOMAXPROCS(0)); v != want {
+		t.Errorf("Name was %q, but expected %q", v, want)
+	}
+
+	for i, want := range []string{"30", "1000000 ns/op", "15.00 ops/s", "1000000 p50-ns", "1000000 p99-ns", "1000000 p99.9-ns", "0.2500 errors/op"} {
+		if v := fields[i+1]; v != want {
+			t.Errorf("Field %d was %q, but expected %q", i+1, v, want)
+		}
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	h := hdrhistogram.New(1, 1000000, 5)
+	if err := h.RecordValue(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	bench := buster.Bench{
+		Duration:      1 * time.Second,
+		MinLatency:    1 * time.Microsecond,
+		MaxLatency:    1 * time.Second,
+		Seed:          7,
+		Partitions:    3,
+		OnWorkerStart: func(int) {},
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	bench.Clock = bustertest.NewClock(start)
+	results := []buster.Result{
+		{Concurrency: 1, Start: start, End: start.Add(1 * time.Second), Elapsed: 1 * time.Second, Success: 10, Latency: h},
+		{Concurrency: 2, Start: start.Add(1 * time.Second), End: start.Add(2 * time.Second), Elapsed: 1 * time.Second, Success: 20, Latency: h},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := buster.WriteManifest(buf, bench, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var m struct {
+		Bench struct {
+			Duration      string `json:"duration"`
+			Seed          int64  `json:"seed"`
+			Partitions    int    `json:"partitions"`
+			OnWorkerStart bool   `json:"on_worker_start"`
+			OnWorkerStop  bool   `json:"on_worker_stop"`
+			Clock         bool   `json:"clock"`
+		} `json:"bench"`
+		GoVersion string                   `json:"go_version"`
+		Start     time.Time                `json:"start"`
+		End       time.Time                `json:"end"`
+		Results   []map[string]interface{} `json:"results"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, want := m.Bench.Duration, "1s"; v != want {
+		t.Errorf("Duration was %q, but expected %q", v, want)
+	}
+
+	if v, want := m.Bench.Seed, int64(7); v != want {
+		t.Errorf("Seed was %d, but expected %d", v, want)
+	}
+
+	if v, want := m.Bench.Partitions, 3; v != want {
+		t.Errorf("Partitions was %d, but expected %d", v, want)
+	}
+
+	if !m.Bench.OnWorkerStart || m.Bench.OnWorkerStop {
+		t.Errorf("Worker hooks were recorded as %v and %v, but expected true and false", m.Bench.OnWorkerStart, m.Bench.OnWorkerStop)
+	}
+
+	if !m.Bench.Clock {
+		t.Error("Clock was not recorded")
+	}
+
+	if m.GoVersion == "" {
+		t.Error("Go version was empty")
+	}
+
+	if !m.Start.Equal(start) || !m.End.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Manifest spanned %v to %v, but expected %v to %v", m.Start, m.End, start, start.Add(2*time.Second))
+	}
+
+	if v, want := len(m.Results), 2; v != want {
+		t.Fatalf("Manifest had %d results, but expected %d", v, want)
+	}
+
+	if v, want := m.Results[1]["success"], 20.0; v != want {
+		t.Errorf("Second result's success count was %v, but expected %v", v, want)
+	}
+}
+
+func TestDoMetric(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return buster.DoMetric(gen, func() (uint64, error) {
+			return 2, nil
+		}, buster.Sum[uint64])
+	})
+
+	total, ok := buster.Metric[uint64](r)
+	if !ok {
+		t.Fatalf("No metric was recorded")
+	}
+
+	if v, want := total, 2*r.Success; v != want {
+		t.Errorf("Metric was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunWarmupOps(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		WarmupOps:  3,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		n := 0
+		return gen.Do(func() error {
+			n++
+			if n <= 3 {
+				return errors.New("still warming up")
+			}
+			return nil
+		})
+	})
+
+	if r.Failure != 0 {
+		t.Errorf("Failure count was %d, but expected warmup operations to be discarded", r.Failure)
+	}
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+}
+
+func TestBenchEstimateDuration(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// 1000 ops/sec, so 10,000 samples for p99 takes about 10s
+	d := bench.EstimateDuration(10, 1000, 99, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if d < 8*time.Second || d > 15*time.Second {
+		t.Errorf("Estimated duration was %v, but expected about 10s", d)
+	}
+}
+
+func TestBenchEstimateDurationInvalid(t *testing.T) {
+	for _, q := range []float64{0, 100, 101, -1} {
+		func() {
+			defer func() {
+				if v, want := recover(), "buster: quantile must be between 0 and 100, exclusive"; v != want {
+					t.Errorf("Panic for quantile %v was %v, but expected %q", q, v, want)
+				}
+			}()
+
+			buster.Bench{}.EstimateDuration(1, 1, q, func(id int, gen *buster.Generator) error {
+				return nil
+			})
+		}()
+	}
+}
+
+func TestBenchRunOnOperation(t *testing.T) {
+	var failures uint64
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		OnOperation: func(op buster.OpResult) {
+			if op.Err != nil {
+				atomic.AddUint64(&failures, 1)
+			}
+		},
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if id == 0 {
+				return errors.New("woo hoo")
+			}
+			return nil
+		})
+	})
+
+	if v, want := atomic.LoadUint64(&failures), r.Failure; v != want {
+		t.Errorf("OnOperation saw %d failures, but expected %d", v, want)
+	}
+}
+
+func TestHandlerJob(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	r := bench.Run(2, 100, buster.HandlerJob(handler, func(id int) *http.Request {
+		path := "/"
+		if id == 1 {
+			path = "/fail"
+		}
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		return req
+	}))
+
+	if v, want := uint64(r.StatusCounts[200]), r.Success; v != want || v == 0 {
+		t.Errorf("200 count was %d, but expected %d", v, want)
+	}
+
+	if v, want := uint64(r.StatusCounts[500]), r.AppFailures; v != want || v == 0 {
+		t.Errorf("500 count was %d, but expected %d", v, want)
+	}
+}
+
+func TestHandlerJobSharedOptions(t *testing.T) {
+	// options with spare capacity, which appending to would overwrite
+	opts := make([]buster.HTTPOption, 1, 2)
+	opts[0] = buster.HTTPTimeout(1 * time.Second)
+
+	buster.HandlerJob(http.NotFoundHandler(), func(id int) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		return req
+	}, opts...)
+
+	if opts[:2][1] != nil {
+		t.Error("HandlerJob modified the caller's options")
+	}
+}
+
+func TestWeightedHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	bench := buster.Bench{
+		Duration:   200 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Seed:       1,
+	}
+
+	endpoint := func(path string) func(int) *http.Request {
+		return func(int) *http.Request {
+			req, _ := http.NewRequest("GET", server.URL+path, nil)
+			return req
+		}
+	}
+
+	r := bench.Run(2, 200, buster.WeightedHTTP([]buster.WeightedRequest{
+		{Name: "a", Weight: 3, Request: endpoint("/a")},
+		{Name: "b", Weight: 1, Request: endpoint("/b")},
+		{Name: "c", Weight: 0, Request: endpoint("/c")},
+	}))
+
+	a := r.Tagged[buster.TagKey(map[string]string{"endpoint": "a"})]
+	b := r.Tagged[buster.TagKey(map[string]string{"endpoint": "b"})]
+	if a == nil || b == nil {
+		t.Fatalf("Tagged results were %v, but expected both endpoints", r.Tagged)
+	}
+
+	if _, ok := r.Tagged[buster.TagKey(map[string]string{"endpoint": "c"})]; ok {
+		t.Error("Endpoint with no weight was requested")
+	}
+
+	if v, want := a.Success, r.Success; v != want {
+		t.Errorf("Endpoint a successes were %d, but expected %d", v, want)
+	}
+
+	if v, want := b.Failure, r.AppFailures; v != want {
+		t.Errorf("Endpoint b failures were %d, but expected %d", v, want)
+	}
+
+	if v, want := uint64(r.StatusCounts[500]), b.Failure; v != want {
+		t.Errorf("500 count was %d, but expected %d", v, want)
+	}
+
+	if a.Success <= b.Failure {
+		t.Errorf("Endpoint a had %d requests and b %d, but expected a to have more", a.Success, b.Failure)
+	}
+}
+
+func TestBenchRunPoissonArrivals(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   500 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Arrivals:   buster.PoissonArrivals,
+		Stagger:    true,
+	}
+
+	r := bench.Run(4, 400, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	// 200 operations are expected on average
+	if r.Success < 100 || r.Success > 300 {
+		t.Errorf("Success count was %d, but expected about 200", r.Success)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	job := func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	}
+
+	results := []buster.Result{
+		bench.Run(1, 100, job),
+		bench.Run(4, 100, job),
+	}
+
+	r := buster.Summarize(results)
+
+	if v, want := r.Concurrency, 4; v != want {
+		t.Errorf("Concurrency was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Success, results[0].Success+results[1].Success; v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Elapsed, 200*time.Millisecond; v != want {
+		t.Errorf("Elapsed was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchRunConcurrently(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	results := make([]buster.Result, 4)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = bench.Run(i+1, 100, func(id int, gen *buster.Generator) error {
+				return gen.DoStatus(func() (int, error) {
+					return 200 + i, nil
+				})
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		if v, want := r.Concurrency, i+1; v != want {
+			t.Errorf("Concurrency of run %d was %d, but expected %d", i, v, want)
+		}
+
+		if v, want := len(r.StatusCounts), 1; v != want || r.StatusCounts[200+i] == 0 {
+			t.Errorf("Status counts of run %d were %v, but expected only %d", i, r.StatusCounts, 200+i)
+		}
+	}
+}
+
+func TestBenchRunResourceLimited(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("file descriptor limits are only checked on Unix")
+	}
+
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if id == 1 {
+				return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("socket", syscall.EMFILE)}
+			}
+			return nil
+		})
+	})
+
+	if !r.ResourceLimited || !strings.Contains(r.ResourceLimit, "file descriptors") {
+		t.Errorf("Result was not resource-limited: %q", r.ResourceLimit)
+	}
+
+	r = bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if r.ResourceLimited {
+		t.Errorf("Result was resource-limited: %q", r.ResourceLimit)
+	}
+
+	bench.FDsPerWorker = 1 << 30
+	bench.Duration = 10 * time.Millisecond
+	r = bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if !r.ResourceLimited || !strings.Contains(r.ResourceLimit, "but the limit is") {
+		t.Errorf("Result was not resource-limited: %q", r.ResourceLimit)
+	}
+}
+
+func TestBenchRunContext(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   10 * time.Second,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// operations block until they're done or the run is cancelled
+	job := func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			select {
+			case <-gen.Context().Done():
+				return gen.Context().Err()
+			case <-time.After(1 * time.Millisecond):
+				return nil
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := bench.RunContext(ctx, 2, 100, job)
+
+	if v := time.Since(start); v > 5*time.Second {
+		t.Fatalf("Run took %v, but expected it to be cancelled", v)
+	}
+
+	if v, want := r.StopReason, buster.StopCancelled; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+
+	if r.Success == 0 {
+		t.Error("No operations succeeded before the run was cancelled")
+	}
+
+	if v, want := r.Failure, uint64(0); v != want {
+		t.Errorf("Failure count was %d, but expected cancelled operations to be excluded", v)
+	}
+
+	if v := r.Elapsed; v <= 0 || v > 5*time.Second {
+		t.Errorf("Elapsed was %v, but expected the time before the cancellation", v)
+	}
+
+	// a worker waiting a whole period for its next operation stops waiting
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start = time.Now()
+	r = bench.RunContext(ctx, 1, 1, job)
+
+	if v, want := time.Since(start), 500*time.Millisecond; v > want {
+		t.Errorf("Run took %v, but expected it to be cancelled within %v", v, want)
+	}
+
+	if v, want := r.StopReason, buster.StopCancelled; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	results := bench.RunPlanContext(ctx, []buster.Stage{
+		{Concurrency: 1, Rate: 100, Duration: 10 * time.Second},
+		{Concurrency: 2, Rate: 100, Duration: 10 * time.Second},
+	}, job)
+
+	if v, want := len(results), 1; v != want {
+		t.Fatalf("Plan ran %d stages, but expected %d", v, want)
+	}
+
+	if v, want := results[0].StopReason, buster.StopDeadline; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchRunContextStuckWorker(t *testing.T) {
+	bench := buster.Bench{
+		Duration:        10 * time.Minute,
+		MinLatency:      1 * time.Microsecond,
+		MaxLatency:      1 * time.Second,
+		ShutdownTimeout: 100 * time.Millisecond,
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := bench.RunContext(ctx, 2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if id == 1 {
+				<-release
+			}
+			return nil
+		})
+	})
+
+	if v := time.Since(start); v > 5*time.Second {
+		t.Fatalf("Run took %v, but expected it to stop after the shutdown timeout", v)
+	}
+
+	if v, want := r.StuckWorkers, 1; v != want {
+		t.Errorf("Stuck workers were %d, but expected %d", v, want)
+	}
+
+	if v, want := r.StopReason, buster.StopCancelled; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchRunContextCancelled(t *testing.T) {
+	errStopped := errors.New("client stopped")
+	bench := buster.Bench{
+		Duration:   10 * time.Second,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Cancelled: func(err error) bool {
+			return errors.Is(err, errStopped)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	r := bench.RunContext(ctx, 2, 100, func(id int, gen *buster.Generator) error {
+		// every operation is in progress until the run is cancelled
+		return gen.Do(func() error {
+			<-gen.Context().Done()
+			return fmt.Errorf("request: %w", errStopped)
+		})
+	})
+
+	if v, want := r.Failure, uint64(0); v != want {
+		t.Errorf("Failure count was %d, but expected cancelled operations to be excluded", v)
+	}
+
+	if v, want := r.StopReason, buster.StopDeadline; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+}
+
+func TestBenchRunMinOps(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   1 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		MinOps:     2,
+	}
+
+	// each worker's period is 100ms, far longer than the duration
+	r := bench.Run(2, 20, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.Success, uint64(4); v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v := r.Elapsed; v < 100*time.Millisecond {
+		t.Errorf("Elapsed was %v, but expected the time the run took", v)
+	}
+}
+
+func TestGeneratorDoEvery(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	// the rate is ignored in favour of the interval
+	r := bench.Run(2, 10000, func(id int, gen *buster.Generator) error {
+		return gen.DoEvery(10*time.Millisecond, func() error {
+			time.Sleep(1 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if r.Success < 10 || r.Success > 22 {
+		t.Errorf("Success count was %d, but expected about 20", r.Success)
+	}
+
+	if v := r.Latency.ValueAtQuantile(50); v > 9000 {
+		t.Errorf("Median latency was %dµs, but expected waits to be excluded", v)
+	}
+}
+
+func TestBenchRunSetupTimeout(t *testing.T) {
+	bench := buster.Bench{
+		Duration:     100 * time.Millisecond,
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Second,
+		SetupTimeout: 10 * time.Millisecond,
+	}
+
+	late := make(chan error, 1)
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		if id == 0 {
+			time.Sleep(50 * time.Millisecond)
+			err := gen.Do(func() error {
+				return nil
+			})
+			late <- err
+			return err
+		}
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.SetupFailures, 1; v != want {
+		t.Errorf("Setup failure count was %d, but expected %d", v, want)
+	}
+
+	if !errors.Is(r.SetupError, buster.ErrSetupTimeout) {
+		t.Errorf("Setup error was %v, but expected %v", r.SetupError, buster.ErrSetupTimeout)
+	}
+
+	if err := <-late; err != buster.ErrSetupTimeout {
+		t.Errorf("Late Do returned %v, but expected %v", err, buster.ErrSetupTimeout)
+	}
+}
+
+func TestBenchRunProbes(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Probes:     1,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if gen.Probe() {
+				time.Sleep(5 * time.Millisecond)
+			}
+			return nil
+		})
+	})
+
+	if r.ProbeLatency == nil {
+		t.Fatal("ProbeLatency was nil")
+	}
+
+	if v, want := r.ProbeLatency.Min(), int64(5000); v < want {
+		t.Errorf("Min probe latency was %dµs, but expected at least %dµs", v, want)
+	}
+
+	if v, want := r.Latency.ValueAtQuantile(50), int64(5000); v >= want {
+		t.Errorf("Median latency was %dµs, but expected less than %dµs", v, want)
+	}
+
+	if v, want := uint64(r.Latency.TotalCount()+r.ProbeLatency.TotalCount()), r.Success; v != want {
+		t.Errorf("Latency count was %d, but expected %d", v, want)
+	}
+}
+
+func TestBenchRunWorkerSeed(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Seed:       42,
+	}
+
+	var mu sync.Mutex
+	first := make(map[int]int64)
+	bench.Run(3, 300, func(id int, gen *buster.Generator) error {
+		v := gen.Rand().Int63()
+		mu.Lock()
+		first[id] = v
+		mu.Unlock()
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	var replayed int64
+	r := bench.RunWorker(3, 300, 1, func(id int, gen *buster.Generator) error {
+		if id != 1 {
+			t.Errorf("Worker id was %d, but expected 1", id)
+		}
+		replayed = gen.Rand().Int63()
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := replayed, first[1]; v != want {
+		t.Errorf("Replayed random value was %d, but expected %d", v, want)
+	}
+
+	if first[0] == first[1] {
+		t.Errorf("Workers 0 and 1 had the same random value %d", first[0])
+	}
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+}
+
+func TestBenchRunAchievedRate(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   200 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 200, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if v, want := r.TargetRate, 200.0; v != want {
+		t.Errorf("Target rate was %f, but expected %f", v, want)
+	}
+
+	if r.RateDiverges(0.25) {
+		t.Errorf("Achieved rate was %f, but expected about %f", r.AchievedRate, r.TargetRate)
+	}
+
+	r = bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			time.Sleep(30 * time.Millisecond)
+			return nil
+		})
+	})
+
+	if !r.RateDiverges(0.25) {
+		t.Errorf("Achieved rate was %f, but expected well under %f", r.AchievedRate, r.TargetRate)
+	}
+}
+
+func TestGeneratorDoAsync(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	var n int64
+	r := bench.Run(2, 200, func(id int, gen *buster.Generator) error {
+		return gen.DoAsync(4, 10*time.Millisecond, func(done func(error)) error {
+			if atomic.AddInt64(&n, 1)%2 == 0 {
+				// never acknowledged, so times out
+				return nil
+			}
+
+			go func() {
+				time.Sleep(2 * time.Millisecond)
+				done(nil)
+			}()
+			return nil
+		})
+	})
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+
+	if r.Failure == 0 {
+		t.Errorf("Failure count was 0, but expected timeouts")
+	}
+
+	if v, want := r.Latency.Min(), int64(2000); v < want {
+		t.Errorf("Min latency was %dµs, but expected at least %dµs", v, want)
+	}
+
+	if r.EffectiveConcurrency <= 0 {
+		t.Errorf("EffectiveConcurrency was %f, but expected the outstanding time to be counted", r.EffectiveConcurrency)
+	}
+}
+
+func TestGeneratorDoAsyncInvalidMax(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		defer func() {
+			if v, want := recover(), "buster: DoAsync max must be positive"; v != want {
+				t.Errorf("Panic was %v, but expected %q", v, want)
+			}
+		}()
+
+		return gen.DoAsync(0, 0, func(done func(error)) error {
+			done(nil)
+			return nil
+		})
+	})
+}
+
+func TestBenchRunLockOSThread(t *testing.T) {
+	bench := buster.Bench{
+		Duration:     50 * time.Millisecond,
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Second,
+		LockOSThread: true,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	if r.Success == 0 {
+		t.Errorf("Success count was 0, but expected more")
+	}
+}
+
+func TestBenchRunSampleResources(t *testing.T) {
+	bench := buster.Bench{
+		Duration:        100 * time.Millisecond,
+		MinLatency:      1 * time.Microsecond,
+		MaxLatency:      1 * time.Second,
+		SampleResources: true,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			// spin, to use some CPU
+			for start := time.Now(); time.Since(start) < 2*time.Millisecond; {
+			}
+			return nil
+		})
+	})
+
+	if r.GeneratorCPU <= 0 {
+		t.Errorf("Generator CPU was %f%%, but expected more", r.GeneratorCPU)
+	}
+
+	if r.GeneratorHeap == 0 {
+		t.Errorf("Generator heap was 0, but expected more")
+	}
+}
+
+func TestGeneratorRecord(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		for i := 0; time.Now().Before(gen.Deadline()); i++ {
+			if i%4 == 0 {
+				gen.Record(0, errors.New("remote failure"))
+			} else {
+				gen.Record(3*time.Millisecond, nil)
+			}
+			time.Sleep(1 * time.Millisecond)
+		}
+		return nil
+	})
+
+	if r.Success == 0 || r.Failure == 0 {
+		t.Errorf("Counts were %d successes and %d failures, but expected both", r.Success, r.Failure)
+	}
+
+	if v, want := r.Latency.Min(), int64(3000); v != want {
+		t.Errorf("Min latency was %dµs, but expected %dµs", v, want)
+	}
+
+	if len(r.Errors) != 0 || r.SetupFailures != 0 {
+		t.Errorf("Job errors were %v and %d setup failures, but expected none", r.Errors, r.SetupFailures)
+	}
+}
+
+func TestBenchRunRetainErrors(t *testing.T) {
+	bench := buster.Bench{
+		Duration:     100 * time.Millisecond,
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Second,
+		RetainErrors: 5,
+	}
+
+	var n int64
+	r := bench.Run(2, 400, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			if atomic.AddInt64(&n, 1)%2 == 0 {
+				return errors.New("even")
+			}
+			return errors.New("odd")
+		})
+	})
+
+	if v, want := uint64(r.ErrorCounts["even"]+r.ErrorCounts["odd"]), r.Failure; v != want {
+		t.Errorf("Error counts totalled %d, but expected %d", v, want)
+	}
+
+	if v, want := len(r.ErrorSamples), 5; v != want {
+		t.Errorf("Retained %d errors, but expected %d", v, want)
+	}
+
+	total := buster.Summarize([]buster.Result{r, r})
+	if v, want := total.ErrorCounts["odd"], 2*r.ErrorCounts["odd"]; v != want {
+		t.Errorf("Combined odd count was %d, but expected %d", v, want)
+	}
+
+	if v, want := len(total.ErrorSamples), 5; v != want {
+		t.Errorf("Combined result retained %d errors, but expected %d", v, want)
+	}
+}
+
+func TestGeneratorDoTagged(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		TagLimit:   2,
+	}
+
+	var n int64
+	r := bench.Run(2, 400, func(id int, gen *buster.Generator) error {
+		return gen.DoTagged(func() (map[string]string, error) {
+			i := atomic.AddInt64(&n, 1)
+			switch {
+			case i == 1 || i > 2 && i%3 == 0:
+				return map[string]string{"endpoint": "read"}, nil
+			case i == 2 || i%3 == 1:
+				if i%2 == 0 {
+					return map[string]string{"endpoint": "write"}, buster.AppError(errors.New("conflict"))
+				}
+				return map[string]string{"endpoint": "write"}, errors.New("failed")
+			default:
+				// the tag limit has been reached by now
+				return map[string]string{"endpoint": fmt.Sprint("scan", i)}, nil
+			}
+		})
+	})
+
+	if v, want := len(r.Tagged), 3; v != want {
+		t.Fatalf("There were %d tag combinations, but expected %d: %v", v, want, r.Tagged)
+	}
+
+	read := r.Tagged[buster.TagKey(map[string]string{"endpoint": "read"})]
+	write := r.Tagged[buster.TagKey(map[string]string{"endpoint": "write"})]
+	other := r.Tagged[buster.OtherTags]
+	if read == nil || write == nil || other == nil {
+		t.Fatalf("Tagged results were %v", r.Tagged)
+	}
+
+	if v, want := read.Success+other.Success, r.Success; v != want {
+		t.Errorf("Tagged success counts totalled %d, but expected %d", v, want)
+	}
+
+	if v, want := write.Failure, r.Failure; v != want {
+		t.Errorf("Tagged failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := write.AppFailures, r.AppFailures; v != want {
+		t.Errorf("Tagged app failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := write.TransportFailures, r.TransportFailures; v != want {
+		t.Errorf("Tagged transport failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := read.Latency.TotalCount(), int64(read.Success); v < want {
+		t.Errorf("Tagged latency count was %d, but expected at least %d", v, want)
+	}
+
+	if v, want := read.Elapsed, r.Elapsed; v != want {
+		t.Errorf("Tagged elapsed was %v, but expected %v", v, want)
+	}
+}
+
+func TestGeneratorDoTaggedWarmup(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		WarmupOps:  5,
+		TagLimit:   1,
+	}
+
+	r := bench.Run(1, 200, func(id int, gen *buster.Generator) error {
+		n := 0
+		return gen.DoTagged(func() (map[string]string, error) {
+			n++
+			if n <= 5 {
+				return map[string]string{"phase": "warmup"}, nil
+			}
+			return map[string]string{"phase": "measured"}, nil
+		})
+	})
+
+	measured := r.Tagged[buster.TagKey(map[string]string{"phase": "measured"})]
+	if measured == nil {
+		t.Fatalf("Tagged results were %v, but expected the measured tags", r.Tagged)
+	}
+
+	if v, want := measured.Success, r.Success; v != want {
+		t.Errorf("Measured success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := len(r.Tagged), 1; v != want {
+		t.Errorf("There were %d tag combinations, but expected %d: %v", v, want, r.Tagged)
+	}
+}
+
+func TestBenchRunPreflight(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Preflight:  true,
+	}
+
+	refused := errors.New("connection refused")
+	var calls int64
+	r := bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			atomic.AddInt64(&calls, 1)
+			return refused
+		})
+	})
+
+	if v, want := r.StopReason, buster.StopUnreachable; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+
+	if !errors.Is(r.SetupError, refused) || r.SetupFailures != 1 || len(r.Errors) != 0 {
+		t.Errorf("Setup error was %v (%d setup failures, errors %v), but expected %v", r.SetupError, r.SetupFailures, r.Errors, refused)
+	}
+
+	if r.Start.IsZero() || r.End.Before(r.Start) {
+		t.Errorf("Result spanned %v to %v, but expected the time of the preflight", r.Start, r.End)
+	}
+
+	if report := r.Report(buster.ReportOptions{}); strings.Contains(report, "NaN") {
+		t.Errorf("Report was\n%s\nbut expected no NaNs", report)
+	}
+
+	if v, want := atomic.LoadInt64(&calls), int64(1); v != want {
+		t.Errorf("Operation was called %d times, but expected %d", v, want)
+	}
+
+	r = bench.Run(2, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return buster.AppError(errors.New("503"))
+		})
+	})
+
+	if v, want := r.StopReason, buster.StopCompleted; v != want {
+		t.Errorf("Stop reason was %v, but expected %v", v, want)
+	}
+
+	if r.Failure == 0 {
+		t.Errorf("Failure count was 0, but expected more")
+	}
+}
+
+func TestBenchRunOptions(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	var seeds [2]int64
+	for i := range seeds {
+		bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+			seeds[i] = gen.Rand().Int63()
+			return gen.Do(func() error {
+				return nil
+			})
+		}, buster.WithSeed(42), buster.WithSampleRate(10), buster.WithWarmup(20*time.Millisecond))
+	}
+
+	if seeds[0] != seeds[1] {
+		t.Errorf("Seeded runs had random values %d and %d, but expected them to match", seeds[0], seeds[1])
+	}
+
+	if v, want := bench.Seed, int64(0); v != want {
+		t.Errorf("Bench seed was %d, but expected options to leave it at %d", v, want)
+	}
+
+	r := bench.Run(1, 100, func(id int, gen *buster.Generator) error {
+		return gen.Do(func() error {
+			return nil
+		})
+	}, buster.WithSampleRate(10))
+
+	if v, want := r.Latency.TotalCount(), int64(r.Success); v >= want {
+		t.Errorf("Latency count was %d, but expected fewer than %d", v, want)
+	}
+}
+
+func TestBenchRunWorkerHooks(t *testing.T) {
+	var mu sync.Mutex
+	started := make(map[int]bool)
+	stopped := make(map[int]error)
+
+	bench := buster.Bench{
+		Duration:   50 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		OnWorkerStart: func(id int) {
+			mu.Lock()
+			defer mu.Unlock()
+			started[id] = true
+		},
+		OnWorkerStop: func(id int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			stopped[id] = err
+		},
+	}
+
+	failed := errors.New("died early")
+	bench.Run(3, 300, func(id int, gen *buster.Generator) error {
+		if id == 2 {
+			return failed
+		}
+		return gen.Do(func() error {
+			return nil
+		})
+	})
+
+	for id := 0; id < 3; id++ {
+		if !started[id] {
+			t.Errorf("Worker %d didn't start", id)
+		}
+
+		err, ok := stopped[id]
+		if !ok {
+			t.Errorf("Worker %d didn't stop", id)
+		}
+
+		var want error
+		if id == 2 {
+			want = failed
+		}
+		if err != want {
+			t.Errorf("Worker %d stopped with %v, but expected %v", id, err, want)
+		}
+	}
+}
+
+func TestBenchRunPartitions(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Partitions: 2,
+	}
+
+	r := bench.Run(4, 200, func(id int, gen *buster.Generator) error {
+		if v, want := gen.Partition(), id%2; v != want {
+			t.Errorf("Worker %d was in partition %d, but expected %d", id, v, want)
+		}
+
+		return gen.Do(func() error {
+			if gen.Partition() == 1 {
+				// a hot shard
+				time.Sleep(5 * time.Millisecond)
+			}
+			return nil
+		})
+	})
+
+	if v, want := len(r.Partitions), 2; v != want {
+		t.Fatalf("There were %d partitions, but expected %d", v, want)
+	}
+
+	if v, want := r.Partitions[0].Success+r.Partitions[1].Success, r.Success; v != want {
+		t.Errorf("Partition success counts totalled %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Partitions[1].Latency.Min(), int64(5000); v < want {
+		t.Errorf("Hot partition's min latency was %dµs, but expected at least %dµs", v, want)
+	}
+
+	if v, want := r.Partitions[0].Latency.ValueAtQuantile(50), int64(5000); v >= want {
+		t.Errorf("Cold partition's median latency was %dµs, but expected less than %dµs", v, want)
+	}
+}
+
+func TestBenchRunPartitionsFailures(t *testing.T) {
+	bench := buster.Bench{
+		Duration:     100 * time.Millisecond,
+		MinLatency:   1 * time.Microsecond,
+		MaxLatency:   1 * time.Second,
+		Partitions:   2,
+		RetainErrors: 10,
+	}
+
+	r := bench.Run(4, 200, func(id int, gen *buster.Generator) error {
+		err := gen.Do(func() error {
+			if gen.Partition() == 1 {
+				return buster.AppError(errors.New("busy"))
+			}
+			return errors.New("refused")
+		})
+		if gen.Partition() == 1 {
+			return errors.New("hot")
+		}
+		return err
+	})
+
+	cold, hot := r.Partitions[0], r.Partitions[1]
+	if cold.Failure == 0 || hot.Failure == 0 {
+		t.Fatalf("Partition failure counts were %d and %d, but expected both to be non-zero", cold.Failure, hot.Failure)
+	}
+
+	if v, want := cold.TransportFailures, cold.Failure; v != want {
+		t.Errorf("Cold partition's transport failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := hot.AppFailures, hot.Failure; v != want {
+		t.Errorf("Hot partition's app failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := cold.AppFailures+hot.TransportFailures, uint64(0); v != want {
+		t.Errorf("Partitions had %d misclassified failures, but expected %d", v, want)
+	}
+
+	if v, want := hot.ErrorCounts["busy"], int(hot.Failure); v != want {
+		t.Errorf("Hot partition counted %d busy errors, but expected %d", v, want)
+	}
+
+	if _, ok := cold.ErrorCounts["busy"]; ok {
+		t.Error("Cold partition counted the hot partition's errors")
+	}
+
+	if v, want := len(hot.Errors), 2; v != want {
+		t.Errorf("Hot partition had %d job errors, but expected %d", v, want)
+	}
+
+	if v, want := len(cold.Errors), 0; v != want {
+		t.Errorf("Cold partition had %d job errors, but expected %d", v, want)
+	}
 }
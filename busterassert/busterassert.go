@@ -0,0 +1,41 @@
+// Package busterassert provides assertions for using buster results as
+// performance gates in Go tests.
+package busterassert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codahale/buster"
+)
+
+// Percentile fails the test if the latency of r at the given quantile (0..100)
+// is greater than budget, or if r has no latencies.
+func Percentile(t testing.TB, r buster.Result, q float64, budget time.Duration) {
+	t.Helper()
+
+	if r.Latency == nil {
+		t.Errorf("no latencies were recorded at concurrency %d", r.Concurrency)
+		return
+	}
+
+	if v := r.Percentile(q); v > budget {
+		t.Errorf("p%g latency at concurrency %d was %v, but budget is %v", q, r.Concurrency, v, budget)
+	}
+}
+
+// ErrorRate fails the test if the fraction of r's operations which failed is
+// greater than max (e.g. 0.01 for 1%).
+func ErrorRate(t testing.TB, r buster.Result, max float64) {
+	t.Helper()
+
+	total := r.Success + r.Failure
+	if total == 0 {
+		t.Errorf("no operations were performed at concurrency %d", r.Concurrency)
+		return
+	}
+
+	if v := float64(r.Failure) / float64(total); v > max {
+		t.Errorf("error rate at concurrency %d was %g (%d of %d), but max is %g", r.Concurrency, v, r.Failure, total, max)
+	}
+}
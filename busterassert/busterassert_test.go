@@ -0,0 +1,64 @@
+package busterassert_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codahale/buster"
+	"github.com/codahale/buster/busterassert"
+	"github.com/codahale/hdrhistogram"
+)
+
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func result() buster.Result {
+	h := hdrhistogram.New(1, 1000000, 5)
+	for i := int64(1); i <= 100; i++ {
+		if err := h.RecordValue(i * 1000); err != nil {
+			panic(err)
+		}
+	}
+
+	return buster.Result{
+		Concurrency: 10,
+		Success:     98,
+		Failure:     2,
+		Latency:     h,
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	r := &recorder{TB: t}
+	busterassert.Percentile(r, result(), 99, 100*time.Millisecond)
+	busterassert.Percentile(r, result(), 99, 10*time.Millisecond)
+	busterassert.Percentile(r, buster.Result{Concurrency: 2}, 99, 10*time.Millisecond)
+
+	want := []string{
+		"p99 latency at concurrency 10 was 99ms, but budget is 10ms",
+		"no latencies were recorded at concurrency 2",
+	}
+	if fmt.Sprint(r.errors) != fmt.Sprint(want) {
+		t.Errorf("Errors were %v, but expected %v", r.errors, want)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	r := &recorder{TB: t}
+	busterassert.ErrorRate(r, result(), 0.05)
+	busterassert.ErrorRate(r, result(), 0.01)
+
+	want := []string{"error rate at concurrency 10 was 0.02 (2 of 100), but max is 0.01"}
+	if fmt.Sprint(r.errors) != fmt.Sprint(want) {
+		t.Errorf("Errors were %v, but expected %v", r.errors, want)
+	}
+}
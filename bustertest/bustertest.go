@@ -0,0 +1,36 @@
+// Package bustertest provides helpers for testing code which consumes buster
+// results, such as reporting pipelines, without a real system under test.
+package bustertest
+
+import (
+	"time"
+
+	"github.com/codahale/buster"
+)
+
+// An Op is the scripted outcome of a single operation.
+type Op struct {
+	Latency time.Duration // how long the operation takes
+	Err     error         // the error the operation returns, if any
+}
+
+// Script returns an operation for use with Generator.Do which, on each
+// successive call, sleeps for the next op's latency and then returns its
+// error. The script repeats once it is exhausted. The returned function is not
+// safe for concurrent use, so each worker should have its own.
+func Script(ops ...Op) func() error {
+	i := 0
+	return func() error {
+		op := ops[i%len(ops)]
+		i++
+		time.Sleep(op.Latency)
+		return op.Err
+	}
+}
+
+// Job returns a job in which every worker performs the given script.
+func Job(ops ...Op) buster.Job {
+	return func(id int, gen *buster.Generator) error {
+		return gen.Do(Script(ops...))
+	}
+}
@@ -0,0 +1,82 @@
+package bustertest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codahale/buster"
+	"github.com/codahale/buster/bustertest"
+)
+
+func TestJob(t *testing.T) {
+	bench := buster.Bench{
+		Duration:   100 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+	}
+
+	r := bench.Run(2, 100, bustertest.Job(
+		bustertest.Op{Latency: 2 * time.Millisecond},
+		bustertest.Op{Err: errors.New("woo hoo")},
+	))
+
+	if r.Success == 0 || r.Failure == 0 {
+		t.Fatalf("Expected successes and failures, but was %d/%d", r.Success, r.Failure)
+	}
+
+	if v, want := r.Latency.Min(), int64(2000); v < want {
+		t.Errorf("Min latency was %dµs, but expected at least %dµs", v, want)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := bustertest.NewClock(start)
+	recorded := make(chan struct{})
+	bench := buster.Bench{
+		Duration:   950 * time.Millisecond,
+		MinLatency: 1 * time.Microsecond,
+		MaxLatency: 1 * time.Second,
+		Clock:      clock,
+		OnOperation: func(buster.OpResult) {
+			recorded <- struct{}{}
+		},
+	}
+
+	results := make(chan buster.Result)
+	go func() {
+		results <- bench.Run(1, 10, func(id int, gen *buster.Generator) error {
+			return gen.Do(clock.Script(
+				bustertest.Op{Latency: 5 * time.Millisecond},
+				bustertest.Op{Latency: 20 * time.Millisecond, Err: errors.New("woo hoo")},
+			))
+		})
+	}()
+
+	// wait for the worker's ticker and the end of the run to be scheduled,
+	// then tick until just past the end of the run
+	clock.BlockUntil(2)
+	for i := 1; i <= 9; i++ {
+		clock.Advance(start.Add(time.Duration(i) * 100 * time.Millisecond).Sub(clock.Now()))
+		<-recorded
+	}
+	clock.Advance(50 * time.Millisecond)
+	r := <-results
+
+	if v, want := r.Success, uint64(5); v != want {
+		t.Errorf("Success count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Failure, uint64(4); v != want {
+		t.Errorf("Failure count was %d, but expected %d", v, want)
+	}
+
+	if v, want := r.Latency.Min(), int64(5000); v != want {
+		t.Errorf("Min latency was %dµs, but expected %dµs", v, want)
+	}
+
+	if v, want := r.Latency.Max(), int64(5000); v != want {
+		t.Errorf("Max latency was %dµs, but expected %dµs", v, want)
+	}
+}
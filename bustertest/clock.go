@@ -0,0 +1,184 @@
+package bustertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/codahale/buster"
+)
+
+// A Clock is a fake buster.Clock whose time only passes when Advance is
+// called, for simulating runs without waiting for them. Set it as a bench's
+// Clock, and have the job's operations advance it by their latencies (e.g.
+// with Script); the test then advances it to each operation's scheduled
+// start, waiting for each to be recorded (e.g. with the bench's OnOperation)
+// before advancing it again, and then past the end of the run.
+type Clock struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewClock returns a Clock whose time starts at the given time.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers which
+// come due in order. As with real tickers, a tick is dropped if the previous
+// one hasn't been received.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.pending {
+			if !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		c.now = next.at
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			c.remove(next)
+		}
+
+		if next.f != nil {
+			go next.f()
+		} else {
+			select {
+			case next.c <- c.now:
+			default:
+			}
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, e.g. until
+// a run's workers have started waiting for their first operations.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) < n {
+		c.changed.Wait()
+	}
+}
+
+// Pending returns the number of timers and tickers which are pending.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// NewTimer returns a timer which fires once the clock has advanced by d.
+func (c *Clock) NewTimer(d time.Duration) buster.Timer {
+	return c.add(&fakeTimer{clock: c, c: make(chan time.Time, 1)}, d)
+}
+
+// NewTicker returns a ticker which fires every time the clock advances by d.
+func (c *Clock) NewTicker(d time.Duration) buster.Ticker {
+	if d <= 0 {
+		panic("bustertest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(&fakeTimer{clock: c, c: make(chan time.Time, 1), period: d}, d)}
+}
+
+// AfterFunc returns a timer which calls f in its own goroutine once the clock
+// has advanced by d.
+func (c *Clock) AfterFunc(d time.Duration, f func()) buster.Timer {
+	return c.add(&fakeTimer{clock: c, f: f}, d)
+}
+
+// Script returns an operation like the package-level Script, except that
+// each op's latency passes on the clock rather than in real time.
+func (c *Clock) Script(ops ...Op) func() error {
+	i := 0
+	return func() error {
+		op := ops[i%len(ops)]
+		i++
+		c.Advance(op.Latency)
+		return op.Err
+	}
+}
+
+func (c *Clock) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.at = c.now.Add(d)
+	c.pending = append(c.pending, t)
+	c.changed.Broadcast()
+	return t
+}
+
+// remove removes t from the pending timers, returning true if it was pending.
+// The clock must be locked.
+func (c *Clock) remove(t *fakeTimer) bool {
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// A fakeTimer is a timer or ticker of a Clock.
+type fakeTimer struct {
+	clock  *Clock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.remove(t)
+	t.at = c.now.Add(d)
+	c.pending = append(c.pending, t)
+	c.changed.Broadcast()
+	return pending
+}
+
+// A fakeTicker is a ticker of a Clock.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,89 @@
+package buster
+
+import "time"
+
+// A Clock is a source of time for a run: the times at which operations start
+// and end, and the tickers and timers which schedule them and end the run. By
+// default, runs use the system clock; a fake clock (such as
+// bustertest.Clock) lets tests script both the passage of time and the
+// latencies of operations without sleeping.
+//
+// A Clock must be safe for concurrent use.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer returns a timer which sends the time on its channel once,
+	// after at least d.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker returns a ticker which sends the time on its channel every
+	// d, dropping ticks for slow receivers.
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine after at least d. The returned
+	// timer's channel is unused.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a single event from a Clock, like a time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// A Ticker is a series of regular events from a Clock, like a time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the Clock used by runs which don't have one.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clock returns the bench's Clock, or the system clock if it has none.
+func (b Bench) clock() Clock {
+	if b.Clock == nil {
+		return systemClock{}
+	}
+	return b.Clock
+}
+
+// after returns a channel on which the clock sends the time once d has
+// elapsed.
+func after(c Clock, d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
@@ -0,0 +1,118 @@
+package buster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// comparisonTolerance is the proportional change beyond which WriteComparison
+// marks a metric as having changed.
+const comparisonTolerance = 0.05
+
+// WriteComparison writes a plain-text table comparing the current results with
+// the baseline results to w, matching results by concurrency level. For each
+// level, it shows the throughput and p50, p99, and p99.9 latencies of both,
+// and the change between them. Changes of more than 5% are marked with ▲ or ▼,
+// and those which are for the worse (lower throughput or higher latency) are
+// also marked as regressions. Levels which are only in one of the sets of
+// results are shown with the other's values missing.
+func WriteComparison(w io.Writer, baseline, current []Result) error {
+	base := make(map[int]Result, len(baseline))
+	curr := make(map[int]Result, len(current))
+	var levels []int
+	for _, r := range baseline {
+		if _, ok := base[r.Concurrency]; !ok {
+			levels = append(levels, r.Concurrency)
+		}
+		base[r.Concurrency] = r
+	}
+	for _, r := range current {
+		if _, ok := base[r.Concurrency]; !ok {
+			if _, ok := curr[r.Concurrency]; !ok {
+				levels = append(levels, r.Concurrency)
+			}
+		}
+		curr[r.Concurrency] = r
+	}
+	sort.Ints(levels)
+
+	out := bytes.NewBuffer(nil)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "concurrency\tmetric\tbaseline\tcurrent\tdelta")
+
+	for _, c := range levels {
+		b, hasBase := base[c]
+		r, hasCurr := curr[c]
+
+		for i, m := range []struct {
+			name      string
+			quantile  float64
+			higherBad bool
+		}{
+			{"ops/sec", 0, false},
+			{"p50", 50, true},
+			{"p99", 99, true},
+			{"p99.9", 99.9, true},
+		} {
+			value := func(r Result) (float64, string) {
+				if m.quantile == 0 {
+					v := throughput(r)
+					return v, fmt.Sprintf("%.1f", v)
+				}
+				if r.Latency == nil {
+					return 0, "-"
+				}
+				v := r.Latency.ValueAtQuantile(m.quantile)
+				return float64(v), formatLatency(time.Duration(v)*time.Microsecond, ReportOptions{Precision: 3})
+			}
+
+			level := ""
+			if i == 0 {
+				level = fmt.Sprint(c)
+			}
+
+			bv, bs, rv, rs := 0.0, "-", 0.0, "-"
+			if hasBase {
+				bv, bs = value(b)
+			}
+			if hasCurr {
+				rv, rs = value(r)
+			}
+
+			delta := "-"
+			if hasBase && hasCurr && bv != 0 {
+				change := (rv - bv) / bv
+				delta = fmt.Sprintf("%+.1f%%", change*100)
+				if change > comparisonTolerance {
+					delta += " ▲"
+				} else if change < -comparisonTolerance {
+					delta += " ▼"
+				}
+				if (m.higherBad && change > comparisonTolerance) ||
+					(!m.higherBad && change < -comparisonTolerance) {
+					delta += " regression"
+				}
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", level, m.name, bs, rs, delta)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// throughput returns the result's successful operations per second.
+func throughput(r Result) float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Success) / r.Elapsed.Seconds()
+}
@@ -0,0 +1,36 @@
+package buster
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// checkRecording panics if the generator's job has already returned, since
+// operations recorded then would be missing from the run's results.
+func (gen *Generator) checkRecording() {
+	if atomic.LoadInt32(&gen.ended) != 0 {
+		panic(fmt.Sprintf("buster: debug: worker %d recorded an operation after its job returned", gen.id))
+	}
+}
+
+// checkRecorded panics if recording a successful operation's latency didn't
+// increase the histogram's count from the given one.
+func (gen *Generator) checkRecorded(count int64) {
+	if gen.hist.TotalCount() <= count {
+		panic(fmt.Sprintf("buster: debug: worker %d counted a success without recording its latency", gen.id))
+	}
+}
+
+// checkOp panics unless an operation which was performed when the
+// generator's count was before recorded exactly one outcome, or none if it
+// wasn't measured.
+func (gen *Generator) checkOp(before int64) {
+	want := int64(0)
+	if gen.measured {
+		want = 1
+	}
+
+	if n := atomic.LoadInt64(&gen.count) - before; n != want {
+		panic(fmt.Sprintf("buster: debug: worker %d recorded %d outcomes for operation %d, but expected %d", gen.id, n, gen.seq, want))
+	}
+}
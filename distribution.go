@@ -0,0 +1,64 @@
+package buster
+
+import "time"
+
+// A Bar is a range of a latency distribution, and the number of operations
+// whose latencies fell in it.
+type Bar struct {
+	From, To time.Duration // the range is [From, To)
+	Count    int64
+}
+
+// LinearDistribution returns the distribution of the result's latencies in
+// successive ranges of the given width, from zero up to the range holding the
+// maximum latency. Ranges with no latencies in them are included.
+func (r Result) LinearDistribution(step time.Duration) []Bar {
+	if step <= 0 {
+		return nil
+	}
+	return r.distribution(step, func(d time.Duration) time.Duration {
+		return d + step
+	})
+}
+
+// LogDistribution returns the distribution of the result's latencies in
+// ranges which grow by the given base (e.g. 2), from zero up to the range
+// holding the maximum latency. The first range is [0, first), the second
+// [first, first*base), and so on. Ranges with no latencies in them are
+// included. The base must be greater than one.
+func (r Result) LogDistribution(base float64, first time.Duration) []Bar {
+	if base <= 1 || first <= 0 {
+		return nil
+	}
+	return r.distribution(first, func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * base)
+	})
+}
+
+// distribution returns the bars [0, first), [first, next(first)), and so on,
+// until one holds the result's maximum latency.
+func (r Result) distribution(first time.Duration, next func(time.Duration) time.Duration) []Bar {
+	if r.Latency == nil || r.Latency.TotalCount() == 0 {
+		return nil
+	}
+
+	var bars []Bar
+	bar := Bar{To: first}
+	for _, b := range r.Latency.Distribution() {
+		if b.Count == 0 {
+			continue
+		}
+
+		v := time.Duration(b.From) * time.Microsecond
+		for v >= bar.To {
+			bars = append(bars, bar)
+			bar = Bar{From: bar.To, To: next(bar.To)}
+			if bar.To <= bar.From {
+				// a small base can round down to no growth at all
+				bar.To = bar.From + 1
+			}
+		}
+		bar.Count += b.Count
+	}
+	return append(bars, bar)
+}
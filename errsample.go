@@ -0,0 +1,129 @@
+package buster
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// An ErrorCount is the number of times a distinct error message occurred.
+type ErrorCount struct {
+	Message string
+	Count   int
+}
+
+// SortErrorCounts returns the given counts of error messages (e.g. a result's
+// ErrorCounts or SetupErrorCounts) in a deterministic order: the most frequent
+// first, with ties broken by message.
+func SortErrorCounts(counts map[string]int) []ErrorCount {
+	sorted := make([]ErrorCount, 0, len(counts))
+	for msg, n := range counts {
+		sorted = append(sorted, ErrorCount{Message: msg, Count: n})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Message < sorted[j].Message
+	})
+	return sorted
+}
+
+// An errorSampler counts the errors of a run's failed operations by message,
+// and keeps a uniform random sample of them.
+type errorSampler struct {
+	mu      sync.Mutex
+	retain  int
+	seen    int
+	samples []error
+	counts  map[string]int
+	rand    *rand.Rand
+}
+
+func newErrorSampler(retain int, seed int64) *errorSampler {
+	return &errorSampler{
+		retain: retain,
+		counts: make(map[string]int),
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// add records err using reservoir sampling, so that every error of the run is
+// equally likely to be in the sample.
+func (s *errorSampler) add(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[err.Error()]++
+	s.seen++
+	if len(s.samples) < s.retain {
+		s.samples = append(s.samples, err)
+	} else if i := s.rand.Intn(s.seen); i < s.retain {
+		s.samples[i] = err
+	}
+}
+
+// load copies the counts and sample into r.
+func (s *errorSampler) load(r *Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// workers which are stuck may still be failing, so copy
+	r.ErrorCounts = make(map[string]int, len(s.counts))
+	for msg, n := range s.counts {
+		r.ErrorCounts[msg] = n
+	}
+	r.ErrorSamples = append([]error(nil), s.samples...)
+	r.retain = s.retain
+}
+
+// addErrorSamples folds the error counts and samples of other into r. The
+// samples are combined so that each of the errors they represent is equally
+// likely to be in the combined sample.
+func (r *Result) addErrorSamples(other Result) {
+	seen := func(r *Result) (n int) {
+		for _, c := range r.ErrorCounts {
+			n += c
+		}
+		return n
+	}
+	mine, theirs := seen(r), seen(&other)
+
+	for msg, n := range other.ErrorCounts {
+		if r.ErrorCounts == nil {
+			r.ErrorCounts = make(map[string]int)
+		}
+		r.ErrorCounts[msg] += n
+	}
+
+	if other.retain > r.retain {
+		r.retain = other.retain
+	}
+	if len(other.ErrorSamples) == 0 {
+		return
+	}
+
+	// each sampled error stands for seen/len(samples) of the errors
+	a := append([]error(nil), r.ErrorSamples...)
+	b := append([]error(nil), other.ErrorSamples...)
+	var wa, wb float64
+	if len(a) > 0 {
+		wa = float64(mine) / float64(len(a))
+	}
+	wb = float64(theirs) / float64(len(b))
+
+	var samples []error
+	for len(samples) < r.retain && len(a)+len(b) > 0 {
+		from := &b
+		if rand.Float64()*(wa*float64(len(a))+wb*float64(len(b))) < wa*float64(len(a)) {
+			from = &a
+		}
+
+		i := rand.Intn(len(*from))
+		samples = append(samples, (*from)[i])
+		(*from)[i] = (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+	}
+	r.ErrorSamples = samples
+}
@@ -0,0 +1,15 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package buster
+
+// fdLimit returns false, as the process's limit on open file descriptors
+// isn't available on this platform.
+func fdLimit() (uint64, bool) {
+	return 0, false
+}
+
+// fdExhausted returns false, as running out of file descriptors can't be
+// detected on this platform.
+func fdExhausted(err error) bool {
+	return false
+}
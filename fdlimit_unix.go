@@ -0,0 +1,23 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package buster
+
+import (
+	"errors"
+	"syscall"
+)
+
+// fdLimit returns the process's soft limit on open file descriptors.
+func fdLimit() (uint64, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, false
+	}
+	return uint64(limit.Cur), true
+}
+
+// fdExhausted returns true if the error is due to the process or system
+// running out of file descriptors.
+func fdExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
@@ -0,0 +1,55 @@
+package buster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// Fingerprint returns a short hexadecimal hash of a summary of the result: its
+// concurrency level, throughput, error rate, and p50, p90, p99, and p99.9
+// latencies. Throughput and latencies are rounded to buckets which grow by the
+// given proportional tolerance (e.g. 0.05 for 5%), and the error rate to
+// buckets of that width, so that runs whose summaries differ by less than the
+// tolerance usually have the same fingerprint, and runs whose summaries differ
+// by more always have different ones. Values close to a bucket boundary may
+// fall either side of it, so equal fingerprints are a cheap signal that
+// performance hasn't changed, not a substitute for WriteComparison. If the
+// tolerance isn't positive, 5% is used.
+func (r Result) Fingerprint(tolerance float64) string {
+	if tolerance <= 0 {
+		tolerance = comparisonTolerance
+	}
+
+	bucket := func(v float64) int64 {
+		if v <= 0 {
+			return math.MinInt64
+		}
+		return int64(math.Floor(math.Log(v) / math.Log1p(tolerance)))
+	}
+
+	errorRate := 0.0
+	if total := r.Success + r.Failure; total > 0 {
+		errorRate = float64(r.Failure) / float64(total)
+	}
+
+	var p50, p90, p99, p999 int64
+	if r.Latency != nil {
+		p50 = r.Latency.ValueAtQuantile(50)
+		p90 = r.Latency.ValueAtQuantile(90)
+		p99 = r.Latency.ValueAtQuantile(99)
+		p999 = r.Latency.ValueAtQuantile(99.9)
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %d %d %d %d %d %d",
+		r.Concurrency,
+		bucket(throughput(r)),
+		int64(math.Floor(errorRate/tolerance)),
+		bucket(float64(p50)),
+		bucket(float64(p90)),
+		bucket(float64(p99)),
+		bucket(float64(p999)),
+	)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
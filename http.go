@@ -0,0 +1,196 @@
+package buster
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// An HTTPOption configures a job returned by HTTPJob.
+type HTTPOption func(*httpConfig)
+
+type httpConfig struct {
+	timeout     time.Duration
+	success     func(status int) bool
+	transport   http.RoundTripper
+	noKeepAlive bool
+}
+
+// HTTPTimeout sets the timeout of each worker's HTTP client. By default,
+// there is no timeout.
+func HTTPTimeout(d time.Duration) HTTPOption {
+	return func(c *httpConfig) {
+		c.timeout = d
+	}
+}
+
+// HTTPSuccess sets the function used to decide whether a response's status
+// code indicates success. By default, only 2xx status codes do.
+func HTTPSuccess(f func(status int) bool) HTTPOption {
+	return func(c *httpConfig) {
+		c.success = f
+	}
+}
+
+// HTTPKeepAlive sets whether each worker's HTTP client reuses connections
+// between requests. By default, it does; if not, each request is made on a
+// new connection.
+func HTTPKeepAlive(enabled bool) HTTPOption {
+	return func(c *httpConfig) {
+		c.noKeepAlive = !enabled
+	}
+}
+
+// HTTPJob returns a job in which each worker has its own HTTP client, with its
+// own connection pool, and repeatedly performs the request returned by req.
+// The response body is read in full and closed, and the status code is
+// recorded in Result.StatusCounts. Errors in performing the
+// request are counted as transport failures, and responses with unsuccessful
+// status codes are counted as application failures (see AppError).
+func HTTPJob(req func(id int) *http.Request, opts ...HTTPOption) Job {
+	cfg := newHTTPConfig(opts)
+	return func(id int, gen *Generator) error {
+		client := cfg.client()
+		return gen.DoStatus(func() (int, error) {
+			return cfg.do(client, req(id))
+		})
+	}
+}
+
+// A WeightedRequest is one of the endpoints of a WeightedHTTP job.
+type WeightedRequest struct {
+	Name    string                     // the endpoint's tag in Result.Tagged
+	Weight  int                        // the relative frequency of its requests
+	Request func(id int) *http.Request // returns a request to the endpoint
+}
+
+// WeightedHTTP returns a job like HTTPJob, except that each operation is a
+// request to one of the given endpoints, chosen at random in proportion to
+// their weights using the generator's Rand. The operations of each endpoint
+// are also counted and recorded in Result.Tagged, under the key
+// TagKey(map[string]string{"endpoint": name}). Endpoints with weights of zero
+// or less are never requested. WeightedHTTP panics if no endpoint has a
+// positive weight.
+func WeightedHTTP(reqs []WeightedRequest, opts ...HTTPOption) Job {
+	cfg := newHTTPConfig(opts)
+
+	total := 0
+	for _, r := range reqs {
+		if r.Weight > 0 {
+			total += r.Weight
+		}
+	}
+	if total == 0 {
+		panic("buster: weighted requests must have a positive total weight")
+	}
+
+	tags := make([]map[string]string, len(reqs))
+	for i, r := range reqs {
+		tags[i] = map[string]string{"endpoint": r.Name}
+	}
+
+	return func(id int, gen *Generator) error {
+		client := cfg.client()
+		return gen.DoTagged(func() (map[string]string, error) {
+			n := gen.Rand().Intn(total)
+			i := 0
+			for ; ; i++ {
+				if reqs[i].Weight <= 0 {
+					continue
+				}
+				if n < reqs[i].Weight {
+					break
+				}
+				n -= reqs[i].Weight
+			}
+			return tags[i], gen.status(cfg.do(client, reqs[i].Request(id)))
+		})
+	}
+}
+
+func newHTTPConfig(opts []HTTPOption) httpConfig {
+	cfg := httpConfig{
+		success: func(status int) bool {
+			return status >= 200 && status < 300
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// client returns a new HTTP client for a worker.
+func (cfg httpConfig) client() *http.Client {
+	transport := cfg.transport
+	if transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DisableKeepAlives = cfg.noKeepAlive
+		transport = t
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.timeout,
+	}
+}
+
+// do performs the request, reading and closing the response body, and returns
+// the response's status code.
+func (cfg httpConfig) do(client *http.Client, req *http.Request) (int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	if e := resp.Body.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if !cfg.success(resp.StatusCode) {
+		return resp.StatusCode, AppError(fmt.Errorf("unexpected status: %s", resp.Status))
+	}
+	return resp.StatusCode, nil
+}
+
+// CompareKeepAlive runs an HTTPJob with the given request and options twice at
+// the given concurrency level and rate, once with connection keep-alive and
+// once with a new connection for each request, and returns the results of
+// both. Apart from keep-alive, the two runs are configured identically.
+func (b Bench) CompareKeepAlive(concurrency, rate int, req func(id int) *http.Request, opts ...HTTPOption) (keepAlive, noKeepAlive Result) {
+	with := append(append([]HTTPOption(nil), opts...), HTTPKeepAlive(true))
+	without := append(append([]HTTPOption(nil), opts...), HTTPKeepAlive(false))
+
+	keepAlive = b.Run(concurrency, rate, HTTPJob(req, with...))
+	noKeepAlive = b.Run(concurrency, rate, HTTPJob(req, without...))
+	return keepAlive, noKeepAlive
+}
+
+// HandlerJob returns a job like HTTPJob, except that requests are served by
+// the given handler in memory rather than sent over the network. This measures
+// the handler in isolation, without any network overhead or flakiness.
+func HandlerJob(h http.Handler, req func(id int) *http.Request, opts ...HTTPOption) Job {
+	opts = append(append([]HTTPOption(nil), opts...), func(c *httpConfig) {
+		c.transport = handlerTransport{h: h}
+	})
+	return HTTPJob(req, opts...)
+}
+
+// handlerTransport is a RoundTripper which serves requests with a handler.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
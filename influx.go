@@ -0,0 +1,66 @@
+package buster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// WriteLineProtocol writes the given results to w in InfluxDB line protocol,
+// one line per result. Each line is tagged with the result's concurrency and
+// the given tags, and is timestamped with the result's End time.
+//
+// The fields are throughput (successful operations per second), success and
+// failure (operation counts), and p50, p90, p99, p999, and max (latencies in
+// µs). Throughput is zero if the result has no Elapsed, and the latency fields
+// are omitted if it has no Latency.
+func WriteLineProtocol(w io.Writer, results []Result, measurement string, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, r := range results {
+		line := bytes.NewBuffer(nil)
+
+		line.WriteString(measurementEscaper.Replace(measurement))
+		fmt.Fprintf(line, ",concurrency=%d", r.Concurrency)
+		for _, k := range keys {
+			fmt.Fprintf(line, ",%s=%s", tagEscaper.Replace(k), tagEscaper.Replace(tags[k]))
+		}
+
+		fmt.Fprintf(line, " throughput=%g,success=%di,failure=%di",
+			throughput(r), r.Success, r.Failure)
+		for _, q := range []struct {
+			name     string
+			quantile float64
+		}{
+			{"p50", 50},
+			{"p90", 90},
+			{"p99", 99},
+			{"p999", 99.9},
+			{"max", 100},
+		} {
+			if r.Latency == nil {
+				break
+			}
+			fmt.Fprintf(line, ",%s=%di", q.name, r.Latency.ValueAtQuantile(q.quantile))
+		}
+
+		fmt.Fprintf(line, " %d\n", r.End.UnixNano())
+
+		if _, err := line.WriteTo(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
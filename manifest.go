@@ -0,0 +1,131 @@
+package buster
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// A manifest describes a set of runs: the bench's configuration, the
+// environment they ran in, and their results.
+type manifest struct {
+	Bench      benchManifest            `json:"bench"`
+	GoVersion  string                   `json:"go_version"`
+	GOOS       string                   `json:"goos"`
+	GOARCH     string                   `json:"goarch"`
+	GOMAXPROCS int                      `json:"gomaxprocs"`
+	NumCPU     int                      `json:"num_cpu"`
+	Hostname   string                   `json:"hostname"`
+	Start      time.Time                `json:"start"`
+	End        time.Time                `json:"end"`
+	Results    []map[string]interface{} `json:"results"`
+}
+
+// A benchManifest is the serializable configuration of a bench. Callbacks and
+// the Clock are recorded only as whether they were set.
+type benchManifest struct {
+	Warmup             string `json:"warmup"`
+	Duration           string `json:"duration"`
+	MinLatency         string `json:"min_latency"`
+	MaxLatency         string `json:"max_latency"`
+	SignificantFigures int    `json:"significant_figures"`
+	WarmupOps          int    `json:"warmup_ops"`
+	MinOps             int    `json:"min_ops"`
+	WidenLatency       bool   `json:"widen_latency"`
+	SampleRate         int    `json:"sample_rate"`
+	Arrivals           string `json:"arrivals"`
+	Stagger            bool   `json:"stagger"`
+	SyncStart          bool   `json:"sync_start"`
+	Probes             int    `json:"probes"`
+	Partitions         int    `json:"partitions"`
+	TagLimit           int    `json:"tag_limit"`
+	RetainErrors       int    `json:"retain_errors"`
+	SampleResources    bool   `json:"sample_resources"`
+	FDsPerWorker       int    `json:"fds_per_worker"`
+	LockOSThread       bool   `json:"lock_os_thread"`
+	Seed               int64  `json:"seed"`
+	Preflight          bool   `json:"preflight"`
+	SetupTimeout       string `json:"setup_timeout"`
+	ShutdownTimeout    string `json:"shutdown_timeout"`
+	Debug              bool   `json:"debug"`
+	SuccessStatus      bool   `json:"success_status"`
+	OnOperation        bool   `json:"on_operation"`
+	OnWorkerStart      bool   `json:"on_worker_start"`
+	OnWorkerStop       bool   `json:"on_worker_stop"`
+	Unreachable        bool   `json:"unreachable"`
+	Cancelled          bool   `json:"cancelled"`
+	Clock              bool   `json:"clock"`
+}
+
+// WriteManifest writes a JSON manifest of the given results to w, so that they
+// can be archived along with what's needed to reproduce them: the bench's
+// configuration (with durations as strings like "1.5s"), the Go version and
+// GOMAXPROCS, the platform, the hostname, and the earliest start and latest
+// end times of the results. Each result is recorded as its ToMap.
+func WriteManifest(w io.Writer, bench Bench, results []Result) error {
+	arrivals := "uniform"
+	if bench.Arrivals == PoissonArrivals {
+		arrivals = "poisson"
+	}
+
+	m := manifest{
+		Bench: benchManifest{
+			Warmup:             bench.Warmup.String(),
+			Duration:           bench.Duration.String(),
+			MinLatency:         bench.MinLatency.String(),
+			MaxLatency:         bench.MaxLatency.String(),
+			SignificantFigures: 5,
+			WarmupOps:          bench.WarmupOps,
+			MinOps:             bench.MinOps,
+			WidenLatency:       bench.WidenLatency,
+			SampleRate:         bench.SampleRate,
+			Arrivals:           arrivals,
+			Stagger:            bench.Stagger,
+			SyncStart:          bench.SyncStart,
+			Probes:             bench.Probes,
+			Partitions:         bench.Partitions,
+			TagLimit:           bench.TagLimit,
+			RetainErrors:       bench.RetainErrors,
+			SampleResources:    bench.SampleResources,
+			FDsPerWorker:       bench.FDsPerWorker,
+			LockOSThread:       bench.LockOSThread,
+			Seed:               bench.Seed,
+			Preflight:          bench.Preflight,
+			SetupTimeout:       bench.SetupTimeout.String(),
+			ShutdownTimeout:    bench.ShutdownTimeout.String(),
+			Debug:              bench.Debug,
+			SuccessStatus:      bench.SuccessStatus != nil,
+			OnOperation:        bench.OnOperation != nil,
+			OnWorkerStart:      bench.OnWorkerStart != nil,
+			OnWorkerStop:       bench.OnWorkerStop != nil,
+			Unreachable:        bench.Unreachable != nil,
+			Cancelled:          bench.Cancelled != nil,
+			Clock:              bench.Clock != nil,
+		},
+		GoVersion:  runtime.Version(),
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		Results:    make([]map[string]interface{}, 0, len(results)),
+	}
+
+	// the hostname is left empty if it's unavailable
+	m.Hostname, _ = os.Hostname()
+
+	for i, r := range results {
+		if i == 0 || r.Start.Before(m.Start) {
+			m.Start = r.Start
+		}
+		if r.End.After(m.End) {
+			m.End = r.End
+		}
+		m.Results = append(m.Results, r.ToMap())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
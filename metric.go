@@ -0,0 +1,74 @@
+package buster
+
+// A Number is any integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Sum is a reducer for DoMetric which adds metrics together.
+func Sum[M Number](acc, m M) M {
+	return acc + m
+}
+
+// Max is a reducer for DoMetric which keeps the largest metric.
+func Max[M Number](acc, m M) M {
+	if m > acc {
+		return m
+	}
+	return acc
+}
+
+// DoMetric generates load using the given function, which returns a custom
+// metric for each operation. The metrics of successful operations are combined
+// with reduce, starting from the first, and the combined metric of all the
+// workers is stored in Result.Metric. Since reduce is also used to combine the
+// metrics of different workers, it must be associative, as Sum and Max are.
+//
+// DoMetric is a function rather than a method of Generator because methods
+// cannot have type parameters.
+func DoMetric[M any](gen *Generator, f func() (M, error), reduce func(acc, m M) M) error {
+	var acc M
+	var ok bool
+
+	err := gen.Do(func() error {
+		m, err := f()
+		if err == nil && gen.measured {
+			if ok {
+				acc = reduce(acc, m)
+			} else {
+				acc, ok = m, true
+			}
+		}
+		return err
+	})
+
+	if ok {
+		gen.metric = acc
+		gen.reduce = func(a, b interface{}) interface{} {
+			return reduce(a.(M), b.(M))
+		}
+	}
+	return err
+}
+
+// Metric returns the combined metric of a result whose workers used DoMetric,
+// and whether there was one of type M.
+func Metric[M any](r Result) (M, bool) {
+	m, ok := r.Metric.(M)
+	return m, ok
+}
+
+// addMetric combines m into r's metric using reduce.
+func (r *Result) addMetric(m interface{}, reduce func(a, b interface{}) interface{}) {
+	if m == nil {
+		return
+	}
+
+	if r.Metric == nil {
+		r.Metric, r.reduce = m, reduce
+		return
+	}
+	r.Metric = reduce(r.Metric, m)
+}
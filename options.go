@@ -0,0 +1,28 @@
+package buster
+
+import "time"
+
+// An Option overrides a bench's configuration for a single call to Run or
+// Runf, without changing the bench itself.
+type Option func(*Bench)
+
+// WithWarmup sets the warmup period of the run.
+func WithWarmup(d time.Duration) Option {
+	return func(b *Bench) {
+		b.Warmup = d
+	}
+}
+
+// WithSeed sets the seed of the run's random sources (see Bench.Seed).
+func WithSeed(seed int64) Option {
+	return func(b *Bench) {
+		b.Seed = seed
+	}
+}
+
+// WithSampleRate sets the run's latency sample rate (see Bench.SampleRate).
+func WithSampleRate(n int) Option {
+	return func(b *Bench) {
+		b.SampleRate = n
+	}
+}
@@ -0,0 +1,47 @@
+package buster
+
+import (
+	"context"
+	"time"
+)
+
+// A Stage is one step of a test plan: a period of load at a fixed concurrency
+// level and rate.
+type Stage struct {
+	Concurrency int
+	Rate        float64
+	Duration    time.Duration
+}
+
+// RunPlan runs the given job through each of the given stages in order,
+// returning the results of each stage. Each stage is run with the bench's
+// settings, but for its own Duration, and the warmup period only applies to
+// the first stage. If a stage doesn't complete (e.g. because its workers got
+// stuck), the plan stops, and the results of the stages run so far are
+// returned.
+func (b Bench) RunPlan(stages []Stage, job Job) []Result {
+	return b.RunPlanContext(context.Background(), stages, job)
+}
+
+// RunPlanContext runs the given job through each of the given stages like
+// RunPlan, except that the plan stops early if the given context is cancelled
+// or its deadline expires, as with RunContext. The stage which was cut short
+// is the last of the returned results.
+func (b Bench) RunPlanContext(ctx context.Context, stages []Stage, job Job) []Result {
+	results := make([]Result, 0, len(stages))
+	for _, s := range stages {
+		if ctx.Err() != nil {
+			break
+		}
+
+		b.Duration = s.Duration
+		r := b.run(ctx, s.Concurrency, s.Rate, -1, job)
+		b.Warmup = 0
+
+		results = append(results, r)
+		if r.StopReason != StopCompleted {
+			break
+		}
+	}
+	return results
+}
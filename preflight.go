@@ -0,0 +1,52 @@
+package buster
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// preflight runs the given job for a single operation, returning an error if
+// it indicates that the target is unreachable.
+func (b Bench) preflight(job Job) error {
+	maxLatency, _ := b.maxLatency()
+	gen := &Generator{
+		hist:          hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+		counts:        new(counters),
+		tags:          newTagRegistry(b.TagLimit),
+		rand:          rand.New(rand.NewSource(b.seed(0))),
+		successStatus: b.SuccessStatus,
+		min:           us(b.MinLatency),
+		max:           us(maxLatency),
+		period:        time.Millisecond,
+		preflight:     true,
+		clock:         b.clock(),
+	}
+
+	err := job(0, gen)
+	if gen.preflightErr != nil {
+		err = gen.preflightErr
+	}
+	if err == nil {
+		return nil
+	}
+
+	unreachable := b.Unreachable
+	if unreachable == nil {
+		unreachable = func(err error) bool {
+			return !IsAppError(err)
+		}
+	}
+	if !unreachable(err) {
+		return nil
+	}
+	return fmt.Errorf("buster: target unreachable: %w", err)
+}
+
+// once performs a single operation for a preflight check, recording its error.
+func (gen *Generator) once(f func() error) {
+	gen.measured = false
+	gen.preflightErr = f()
+}
@@ -0,0 +1,38 @@
+package buster
+
+import (
+	"sort"
+	"time"
+)
+
+// A RatePoint is the latency of a run at the throughput it achieved.
+type RatePoint struct {
+	Concurrency int
+	Rate        float64 // successful operations per second
+
+	P50, P90, P99, P999, Max time.Duration
+}
+
+// ByRate returns the latency percentiles of the given results by the
+// throughput each achieved, in order of increasing throughput. This turns a
+// sweep of concurrency levels into a latency-vs-throughput curve, which is
+// often the more meaningful view, without rerunning it.
+func ByRate(results []Result) []RatePoint {
+	points := make([]RatePoint, 0, len(results))
+	for _, r := range results {
+		points = append(points, RatePoint{
+			Concurrency: r.Concurrency,
+			Rate:        throughput(r),
+			P50:         r.Percentile(50),
+			P90:         r.Percentile(90),
+			P99:         r.Percentile(99),
+			P999:        r.Percentile(99.9),
+			Max:         r.Percentile(100),
+		})
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Rate < points[j].Rate
+	})
+	return points
+}
@@ -0,0 +1,92 @@
+package buster
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// resourceInterval is how often the generator's heap is sampled.
+const resourceInterval = 100 * time.Millisecond
+
+// A resourceSampler measures the CPU time and peak heap usage of the process
+// over a run.
+type resourceSampler struct {
+	start time.Time
+	cpu   time.Duration
+	heap  uint64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// sampleResources starts measuring the process's resource usage.
+func sampleResources() *resourceSampler {
+	s := &resourceSampler{
+		start: time.Now(),
+		cpu:   cpuTime(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(resourceInterval)
+		defer ticker.Stop()
+
+		for {
+			s.sampleHeap()
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				s.sampleHeap()
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *resourceSampler) sampleHeap() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > s.heap {
+		s.heap = stats.HeapAlloc
+	}
+}
+
+// finish stops measuring and records the usage in r.
+func (s *resourceSampler) finish(r *Result) {
+	close(s.stop)
+	<-s.done
+
+	if elapsed := time.Since(s.start); elapsed > 0 {
+		r.GeneratorCPU = 100 * float64(cpuTime()-s.cpu) / float64(elapsed)
+	}
+	r.GeneratorHeap = s.heap
+}
+
+// fdShortage returns why the given number of workers would likely exhaust the
+// process's file descriptors, or an empty string if they wouldn't.
+func (b Bench) fdShortage(workers int) string {
+	if b.FDsPerWorker <= 0 {
+		return ""
+	}
+
+	limit, ok := fdLimit()
+	if need := uint64(workers) * uint64(b.FDsPerWorker); ok && need > limit {
+		return fmt.Sprintf("%d workers need about %d file descriptors, but the limit is %d", workers, need, limit)
+	}
+	return ""
+}
+
+// limitedBy marks r as ResourceLimited for the given reason.
+func (r *Result) limitedBy(reason string) {
+	r.ResourceLimited = true
+	if r.ResourceLimit == "" {
+		r.ResourceLimit = reason
+	} else if reason != "" {
+		r.ResourceLimit += "; " + reason
+	}
+}
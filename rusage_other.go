@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package buster
+
+import "time"
+
+// cpuTime returns zero, as the process's CPU time isn't available on this
+// platform.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,126 @@
+package buster
+
+import (
+	"math"
+	"time"
+)
+
+// RunStable runs the given job at the given concurrency level, at the given
+// rate, until the latency at the given quantile (0..100) stabilizes, returning
+// a set of results with aggregated latency and throughput measurements.
+//
+// The job is run in successive windows of the bench's Duration, and stops once
+// the quantile of a window is within tolerance (e.g. 0.05 for 5%) of the
+// previous window's, or once maxDuration has elapsed. The warmup period only
+// applies to the first window. The returned Result aggregates every window,
+// and its Elapsed field is the total time taken.
+func (b Bench) RunStable(concurrency, rate int, quantile, tolerance float64, maxDuration time.Duration, job Job) Result {
+	var total Result
+	var busy float64
+	prev := int64(-1)
+
+	for total.Elapsed < maxDuration {
+		r := b.Run(concurrency, rate, job)
+		b.Warmup = 0
+
+		if total.Latency == nil {
+			total = r
+		} else {
+			total.Add(r)
+			total.Elapsed += r.Elapsed
+			total.End = r.End
+			total.StopReason = r.StopReason
+		}
+		busy += r.EffectiveConcurrency * float64(r.Elapsed)
+		total.EffectiveConcurrency = busy / float64(total.Elapsed)
+		if r.StopReason != StopCompleted {
+			break
+		}
+
+		v := r.Latency.ValueAtQuantile(quantile)
+		if prev >= 0 && math.Abs(float64(v-prev)) <= tolerance*float64(prev) {
+			break
+		}
+		prev = v
+	}
+
+	return total
+}
+
+// tailSamples is the number of samples EstimateDuration aims to collect beyond
+// the quantile of interest, so that it is resolved reliably.
+const tailSamples = 100
+
+// EstimateDuration runs the given job for the bench's Duration as a probe, and
+// uses the throughput it achieves to estimate how long a run at the same
+// concurrency level and rate needs to last in order to resolve the given
+// quantile (0..100, exclusive) reliably. The estimate is the time needed to
+// collect enough samples that 100 of them lie beyond the quantile, and does not
+// include any warmup period. Zero is returned if the probe has no successful
+// operations. EstimateDuration panics if quantile isn't between 0 and 100.
+func (b Bench) EstimateDuration(concurrency, rate int, quantile float64, job Job) time.Duration {
+	if !(quantile > 0 && quantile < 100) {
+		panic("buster: quantile must be between 0 and 100, exclusive")
+	}
+
+	r := b.Run(concurrency, rate, job)
+	if r.Success == 0 || r.Elapsed <= 0 {
+		return 0
+	}
+
+	throughput := float64(r.Success) / r.Elapsed.Seconds()
+	samples := tailSamples / (1 - quantile/100)
+	return time.Duration(samples / throughput * float64(time.Second))
+}
+
+// RunConverged runs the given job at the given concurrency level, at the given
+// rate, until the target reaches a steady state, and then measures it for the
+// bench's Duration. Instead of a fixed warmup period, the job is run in
+// successive windows of the given length, until both the throughput and the
+// p99 latency of a window are within tolerance (e.g. 0.05 for 5%) of the
+// previous window's, or until maxWarmup has elapsed. The bench's Warmup is
+// ignored. The returned Result is that of the measured run, and its Warmup
+// field is how long the warmup windows took in total.
+//
+// Each window is a separate run, so jobs are restarted between them; only
+// the state of the target carries over. RunConverged panics if window or
+// maxWarmup isn't positive.
+func (b Bench) RunConverged(concurrency, rate int, window, maxWarmup time.Duration, tolerance float64, job Job) Result {
+	if window <= 0 {
+		panic("buster: converged window must be positive")
+	}
+	if maxWarmup <= 0 {
+		panic("buster: converged max warmup must be positive")
+	}
+
+	warm := b
+	warm.Warmup = 0
+	warm.Duration = window
+
+	var warmup time.Duration
+	var prev Result
+	for warmup < maxWarmup {
+		r := warm.Run(concurrency, rate, job)
+		warmup += r.Elapsed
+		if r.StopReason != StopCompleted {
+			r.Warmup = warmup
+			return r
+		}
+
+		if prev.Latency != nil && within(throughput(r), throughput(prev), tolerance) &&
+			within(float64(r.Percentile(99)), float64(prev.Percentile(99)), tolerance) {
+			break
+		}
+		prev = r
+	}
+
+	b.Warmup = 0
+	r := b.Run(concurrency, rate, job)
+	r.Warmup = warmup
+	return r
+}
+
+// within returns true if v is within the given proportion of prev.
+func within(v, prev, tolerance float64) bool {
+	return math.Abs(v-prev) <= tolerance*prev
+}
@@ -0,0 +1,110 @@
+package buster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	svgWidth, svgHeight = 640, 400
+	svgMargin           = 60
+)
+
+var svgColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b"}
+
+// WriteSVG writes a standalone SVG line chart of the given results to w, with
+// concurrency on the X axis and latency on the Y axis, and one line for each
+// of the given quantiles (0..100). Results are plotted in order of
+// concurrency, and results without latencies are skipped.
+func WriteSVG(w io.Writer, results []Result, quantiles []float64) error {
+	out := bytes.NewBuffer(nil)
+
+	plotted := make([]Result, 0, len(results))
+	for _, r := range results {
+		if r.Latency != nil {
+			plotted = append(plotted, r)
+		}
+	}
+	sort.SliceStable(plotted, func(i, j int) bool {
+		return plotted[i].Concurrency < plotted[j].Concurrency
+	})
+	results = plotted
+
+	minX, maxX := 0, 1
+	var maxY int64 = 1
+	for i, r := range results {
+		if i == 0 || r.Concurrency < minX {
+			minX = r.Concurrency
+		}
+		if i == 0 || r.Concurrency > maxX {
+			maxX = r.Concurrency
+		}
+		for _, q := range quantiles {
+			if v := r.Latency.ValueAtQuantile(q); v > maxY {
+				maxY = v
+			}
+		}
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+
+	plotW, plotH := float64(svgWidth-2*svgMargin), float64(svgHeight-2*svgMargin)
+	x := func(c int) float64 {
+		return svgMargin + float64(c-minX)/float64(maxX-minX)*plotW
+	}
+	y := func(v int64) float64 {
+		return svgMargin + plotH - float64(v)/float64(maxY)*plotH
+	}
+
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(out, `<rect width="%d" height="%d" fill="white"/>`+"\n", svgWidth, svgHeight)
+
+	// horizontal gridlines and latency labels
+	for i := 0; i <= 5; i++ {
+		v := maxY * int64(i) / 5
+		fmt.Fprintf(out, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`+"\n",
+			svgMargin, y(v), svgWidth-svgMargin, y(v))
+		fmt.Fprintf(out, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n",
+			svgMargin-5, y(v)+4, formatLatency(time.Duration(v)*time.Microsecond, ReportOptions{Precision: 1}))
+	}
+
+	// vertical gridlines and concurrency labels
+	for _, r := range results {
+		fmt.Fprintf(out, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#eee"/>`+"\n",
+			x(r.Concurrency), svgMargin, x(r.Concurrency), svgHeight-svgMargin)
+		fmt.Fprintf(out, `<text x="%.1f" y="%d" text-anchor="middle">%d</text>`+"\n",
+			x(r.Concurrency), svgHeight-svgMargin+15, r.Concurrency)
+	}
+
+	// axes
+	fmt.Fprintf(out, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n",
+		svgMargin, svgMargin, svgMargin, svgHeight-svgMargin)
+	fmt.Fprintf(out, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n",
+		svgMargin, svgHeight-svgMargin, svgWidth-svgMargin, svgHeight-svgMargin)
+	fmt.Fprintf(out, `<text x="%d" y="%d" text-anchor="middle">concurrency</text>`+"\n",
+		svgWidth/2, svgHeight-svgMargin/3)
+
+	// one labelled line per quantile
+	for i, q := range quantiles {
+		color := svgColors[i%len(svgColors)]
+
+		points := make([]string, len(results))
+		for j, r := range results {
+			points[j] = fmt.Sprintf("%.1f,%.1f", x(r.Concurrency), y(r.Latency.ValueAtQuantile(q)))
+		}
+		fmt.Fprintf(out, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n",
+			strings.Join(points, " "), color)
+		fmt.Fprintf(out, `<text x="%d" y="%d" fill="%s">p%g</text>`+"\n",
+			svgWidth-svgMargin+5, svgMargin+15*i, color, q)
+	}
+
+	out.WriteString("</svg>\n")
+
+	_, err := out.WriteTo(w)
+	return err
+}
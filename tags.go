@@ -0,0 +1,125 @@
+package buster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// OtherTags is the key in Result.Tagged of the operations whose tags were
+// first seen after the bench's TagLimit was reached.
+const OtherTags = "other"
+
+// defaultTagLimit is the number of distinct tag combinations recorded if the
+// bench has no TagLimit.
+const defaultTagLimit = 100
+
+// TagKey returns the key in Result.Tagged of operations with the given tags:
+// the tags' key=value pairs, sorted by key and separated by commas.
+func TagKey(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// A tagRegistry is the set of distinct tag combinations recorded by a run's
+// generators, up to a limit.
+type tagRegistry struct {
+	mu    sync.Mutex
+	limit int
+	keys  map[string]bool
+}
+
+func newTagRegistry(limit int) *tagRegistry {
+	if limit <= 0 {
+		limit = defaultTagLimit
+	}
+	return &tagRegistry{limit: limit, keys: make(map[string]bool)}
+}
+
+// key returns the key under which operations with the given tags are recorded.
+func (t *tagRegistry) key(tags map[string]string) string {
+	key := TagKey(tags)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.keys[key] {
+		if len(t.keys) >= t.limit {
+			return OtherTags
+		}
+		t.keys[key] = true
+	}
+	return key
+}
+
+// DoTagged generates load using the given function, which returns the tags
+// (e.g. the tenant or endpoint) of each operation it performs along with its
+// outcome. Operations are counted and recorded as with Do, and also by their
+// tags in Result.Tagged. To bound the memory this uses, at most the bench's
+// TagLimit distinct combinations of tags are recorded over the run; the
+// operations of any further combinations are recorded under OtherTags.
+func (gen *Generator) DoTagged(f func() (map[string]string, error)) error {
+	var key string
+	tagged := func(key string) *Result {
+		if gen.tagged == nil {
+			gen.tagged = make(map[string]*Result)
+		}
+
+		r, ok := gen.tagged[key]
+		if !ok {
+			r = &Result{Latency: hdrhistogram.New(gen.min, gen.max, 5)}
+			gen.tagged[key] = r
+		}
+		return r
+	}
+
+	ok := gen.ok(gen.interval())
+	return gen.loop(func() error {
+		tags, err := f()
+		if !gen.measured {
+			// warmup operations mustn't use up the tag limit
+			return err
+		}
+
+		key = gen.tags.key(tags)
+		if err != nil && !gen.abandoned(err) {
+			r := tagged(key)
+			r.Failure++
+			if IsAppError(err) {
+				r.AppFailures++
+			} else {
+				r.TransportFailures++
+			}
+		}
+		return err
+	}, func(start, end time.Time) {
+		ok(start, end)
+
+		r := tagged(key)
+		r.Success++
+		if end.IsZero() {
+			return
+		}
+
+		gen.recordIn(&r.Latency, us(end.Sub(start)), gen.interval(), 1)
+	})
+}
+
+func (r *Result) addTagged(tagged map[string]*Result) {
+	for key, sub := range tagged {
+		if r.Tagged == nil {
+			r.Tagged = make(map[string]*Result)
+		}
+		if r.Tagged[key] == nil {
+			r.Tagged[key] = &Result{Concurrency: r.Concurrency, Elapsed: r.Elapsed}
+		}
+		r.Tagged[key].Add(*sub)
+	}
+}
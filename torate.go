@@ -0,0 +1,43 @@
+package buster
+
+import "time"
+
+// rateTolerance is how far short of its target rate a run can fall and still
+// be considered to have met it.
+const rateTolerance = 0.05
+
+// RunToRate finds the concurrency level needed to perform the given job at the
+// given rate, and measures the job at that level. Starting with one worker, it
+// runs the job for the bench's Duration at successively doubled concurrency
+// levels, up to maxConcurrency, until a run achieves within 5% of the rate.
+// It then runs the job again at that level and returns the result, whose
+// Concurrency is the level it took, and true.
+//
+// If the latency at the given quantile (0..100) of a run exceeds budget, or
+// the rate isn't achieved at maxConcurrency, the target rate is unreachable,
+// and the result of the last run is returned with false. A budget of zero
+// means latency is unbounded.
+func (b Bench) RunToRate(rate float64, maxConcurrency int, quantile float64, budget time.Duration, job Job) (Result, bool) {
+	var r Result
+	for c := 1; ; c *= 2 {
+		if c > maxConcurrency {
+			c = maxConcurrency
+		}
+
+		r = b.Runf(c, rate, job)
+		if r.StopReason != StopCompleted {
+			return r, false
+		}
+		if budget > 0 && r.Percentile(quantile) > budget {
+			return r, false
+		}
+
+		if r.AchievedRate >= rate*(1-rateTolerance) {
+			return b.Runf(c, rate, job), true
+		}
+
+		if c == maxConcurrency {
+			return r, false
+		}
+	}
+}
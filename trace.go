@@ -0,0 +1,188 @@
+package buster
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// A TraceEvent is a single recorded operation.
+type TraceEvent struct {
+	// Offset is the time the operation was issued, relative to the start of
+	// the trace.
+	Offset time.Duration
+
+	// Op describes the operation. Its meaning is up to the TraceJob.
+	Op interface{}
+}
+
+// A Trace is a source of TraceEvents, in order of increasing offset.
+type Trace interface {
+	// Next returns the next event in the trace, or io.EOF if there are no
+	// more events.
+	Next() (TraceEvent, error)
+}
+
+// A TraceJob performs a single operation from a trace.
+type TraceJob func(id int, event TraceEvent) error
+
+// Replay replays the given trace using the given number of concurrent
+// workers, returning a set of results with aggregated latency and throughput
+// measurements.
+//
+// Each event is scheduled at its offset divided by speed, so a speed of 2
+// replays the trace twice as fast as it was recorded. Latency is measured from
+// an event's scheduled time, not from when a worker became free to perform it,
+// so a backlog of events is reflected in the results. Events scheduled during
+// the warmup period are performed but not recorded. If Duration is non-zero,
+// the replay stops once Warmup and Duration have elapsed; otherwise it stops
+// at the end of the trace. If the bench has a ShutdownTimeout, the replay
+// waits at most that long for a worker to take each event, and for the
+// workers to finish once the events have run out; workers which haven't by
+// then are counted in StuckWorkers.
+//
+// The bench's latency bounds, OnOperation, RetainErrors, Debug, and Clock
+// apply as they do to Run. Options which shape a run's schedule, like
+// Arrivals, SampleRate, and Partitions, are ignored.
+//
+// As with Runf, if concurrency is zero, a single worker performs the events,
+// and the returned Result has a Concurrency of zero. Replay panics if
+// concurrency is negative or speed isn't positive.
+func (b Bench) Replay(concurrency int, speed float64, trace Trace, job TraceJob) Result {
+	if job == nil {
+		panic("buster: job function must not be nil")
+	}
+	if concurrency < 0 {
+		panic("buster: replay concurrency must not be negative")
+	}
+	if !(speed > 0) {
+		panic("buster: replay speed must be positive")
+	}
+
+	workers := concurrency
+	if workers == 0 {
+		workers = 1
+	}
+
+	type scheduled struct {
+		event TraceEvent
+		at    time.Time
+	}
+
+	maxLatency, _ := b.maxLatency()
+	result := Result{
+		Concurrency: concurrency,
+		Latency:     hdrhistogram.New(us(b.MinLatency), us(maxLatency), 5),
+	}
+	counts := new(counters)
+	gens := make(chan *Generator, workers)
+	events := make(chan scheduled, workers)
+
+	clock := b.clock()
+	start := clock.Now()
+	warmed := start.Add(b.Warmup)
+
+	var errs *errorSampler
+	if b.RetainErrors > 0 {
+		errs = newErrorSampler(b.RetainErrors, b.seed(workers))
+	}
+
+	for i := 0; i < workers; i++ {
+		go func(id int) {
+			gen := b.generator(id, counts, errs, clock)
+			for s := range events {
+				began := gen.now()
+				err := job(id, s.event)
+				end := gen.clamp(s.at, gen.now())
+				if s.at.Before(warmed) {
+					continue
+				}
+
+				gen.busy += end.Sub(began)
+				if err == nil {
+					gen.succeed(us(end.Sub(s.at)), 0)
+				} else {
+					gen.fail(err)
+				}
+				gen.observe(s.at, end.Sub(s.at), err)
+			}
+			atomic.StoreInt32(&gen.ended, 1)
+
+			gens <- gen
+		}(i)
+	}
+
+replay:
+	for {
+		event, err := trace.Next()
+		if err != nil {
+			if err != io.EOF {
+				result.Errors = append(result.Errors, err)
+				result.StopReason = StopTraceError
+			}
+			break
+		}
+
+		offset := time.Duration(float64(event.Offset) / speed)
+		if b.Duration > 0 && offset >= b.Warmup+b.Duration {
+			break
+		}
+
+		at := start.Add(offset)
+		<-after(clock, at.Sub(clock.Now()))
+		s := scheduled{event: event, at: at}
+		select {
+		case events <- s:
+			continue
+		default:
+		}
+
+		// every worker is busy, so wait for one, but not forever
+		var stuck <-chan time.Time
+		if b.ShutdownTimeout > 0 {
+			stuck = after(clock, b.ShutdownTimeout)
+		}
+		select {
+		case events <- s:
+		case <-stuck:
+			result.StopReason = StopShutdownTimeout
+			break replay
+		}
+	}
+	close(events)
+
+	var timeout <-chan time.Time
+	if b.ShutdownTimeout > 0 {
+		timeout = after(clock, b.ShutdownTimeout)
+	}
+
+	var overflows []overflow
+	var busy time.Duration
+collect:
+	for i := 0; i < workers; i++ {
+		select {
+		case gen := <-gens:
+			result.Latency.Merge(gen.hist)
+			overflows = append(overflows, gen.overflow...)
+			busy += gen.busy
+		case <-timeout:
+			result.StuckWorkers = workers - i
+			result.StopReason = StopShutdownTimeout
+			break collect
+		}
+	}
+
+	result.Start = warmed
+	result.End = clock.Now()
+	result.Elapsed = result.End.Sub(warmed)
+	counts.load(&result)
+	if errs != nil {
+		errs.load(&result)
+	}
+	result.Latency = widen(result.Latency, overflows)
+	result.EffectiveConcurrency = effective(busy, result.Elapsed)
+
+	return result
+}